@@ -0,0 +1,447 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// accessorPath is a path to a singular field, possibly through singular
+// embedded messages.
+type accessorPath struct {
+	// Name of the path, with field names joined by "__"
+	name string
+	// Field at the end of the path
+	field *descriptorpb.FieldDescriptorProto
+}
+
+// accessorPaths returns the paths accessors are generated for in a message.
+func (g *Generator) accessorPaths(structName string, visiting map[string]bool) ([]accessorPath, error) {
+	if visiting[structName] {
+		return nil, errors.New("recursive messages forbidden: " + structName)
+	}
+	visiting[structName] = true
+	defer delete(visiting, structName)
+
+	descriptor, ok := g.messages[structName]
+	if !ok {
+		return nil, errors.New("unknown message: " + structName)
+	}
+
+	paths := []accessorPath{}
+	for _, field := range descriptor.GetField() {
+		if isFieldRepeated(field) {
+			continue
+		}
+
+		paths = append(paths, accessorPath{field.GetName(), field})
+
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return nil, err
+			}
+
+			nestedPaths, err := g.accessorPaths(fieldTypeName, visiting)
+			if err != nil {
+				return nil, err
+			}
+			for _, nestedPath := range nestedPaths {
+				paths = append(paths, accessorPath{field.GetName() + "__" + nestedPath.name, nestedPath.field})
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// Generate accessors that read a single field without decoding the whole message
+func (g *Generator) generateMessageAccessors(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	for _, field := range fields {
+		if isFieldRepeated(field) {
+			continue
+		}
+
+		fieldName := field.GetName()
+		fieldNumber := field.GetNumber()
+
+		returnType, err := toSolReturnType(field)
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + fieldName)
+		}
+
+		b.P(fmt.Sprintf("// %s.%s", structName, fieldName))
+		generateAccessorHeader(fieldName, fieldNumber, returnType, b)
+		err = g.generateAccessorValue(structName, field, "return (false, v);", b)
+		if err != nil {
+			return err
+		}
+		b.P("// Field must be within the message")
+		b.P("if (pos > initial_pos + len) {")
+		b.Indent()
+		b.P("return (false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("return (true, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+
+		// Accessors for fields of the embedded message delegate to its codec
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		nestedPaths, err := g.accessorPaths(fieldTypeName, map[string]bool{structName: true})
+		if err != nil {
+			return err
+		}
+
+		for _, nestedPath := range nestedPaths {
+			returnType, err := toSolReturnType(nestedPath.field)
+			if err != nil {
+				return err
+			}
+
+			b.P(fmt.Sprintf("// %s.%s.%s", structName, fieldName, strings.ReplaceAll(nestedPath.name, "__", ".")))
+			generateAccessorHeader(fieldName+"__"+nestedPath.name, fieldNumber, returnType, b)
+
+			b.P("uint64 nested_len;")
+			b.P("(success, pos, nested_len) = ProtobufLib.decode_embedded_message(pos, buf);")
+			b.P("if (!success) {")
+			b.Indent()
+			b.P("return (false, v);")
+			b.Unindent()
+			b.P("}")
+			b.P()
+
+			// Wrappers carry presence, so may hold the default value
+			if !g.isFieldWrapper(field) {
+				b.P("// Default value must be omitted")
+				b.P("if (nested_len == 0) {")
+				b.Indent()
+				b.P("return (false, v);")
+				b.Unindent()
+				b.P("}")
+				b.P()
+			}
+
+			b.P("// Field must be within the message")
+			b.P("if (pos + nested_len > initial_pos + len) {")
+			b.Indent()
+			b.P("return (false, v);")
+			b.Unindent()
+			b.P("}")
+			b.P()
+
+			b.P(fmt.Sprintf("return %sCodec.get_%s(pos, buf, nested_len);", fieldTypeName, nestedPath.name))
+			b.Unindent()
+			b.P("}")
+			b.P()
+		}
+	}
+
+	return nil
+}
+
+// generateFindField generates a function that scans keys for a field, skipping
+// over other fields while checking their order and wire types.
+func (g *Generator) generateFindField(fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) {
+	// Repeated messages have one key per element, so their field number repeats
+	repeatedMessages := []string{}
+	for _, field := range fields {
+		if isFieldRepeated(field) && field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			repeatedMessages = append(repeatedMessages, fmt.Sprintf("field_number == %d", field.GetNumber()))
+		}
+	}
+
+	b.P("// Find the value of a field, returns whether it was found and its position")
+	b.P("function find_field(uint64 initial_pos, bytes memory buf, uint64 len, uint64 target) internal pure returns (bool, bool, uint64) {")
+	b.Indent()
+	b.P("// Previous field number")
+	b.P("uint64 previous_field_number = 0;")
+	b.P("// Current position in the buffer")
+	b.P("uint64 pos = initial_pos;")
+	b.P()
+
+	b.P("// Sanity checks")
+	b.P("if (pos + len < pos) {")
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("while (pos - initial_pos < len) {")
+	b.Indent()
+	b.P("// Decode the key (field number and wire type)")
+	b.P("bool success;")
+	b.P("uint64 field_number;")
+	b.P("ProtobufLib.WireType wire_type;")
+	b.P("(success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Check that the field number is within bounds")
+	b.P(fmt.Sprintf("if (field_number > %d) {", len(fields)))
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Check that the field number of monotonically increasing")
+	if len(repeatedMessages) > 0 {
+		b.P(fmt.Sprintf("if (field_number < previous_field_number || (field_number == previous_field_number && !(%s))) {", strings.Join(repeatedMessages, " || ")))
+	} else {
+		b.P("if (field_number <= previous_field_number) {")
+	}
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Check that the wire type is correct")
+	b.P("success = check_key(field_number, wire_type);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Fields are ordered, so the target can't come after a larger field number")
+	b.P("if (field_number >= target) {")
+	b.Indent()
+	b.P("return (true, field_number == target, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("(success, pos) = ProtobufSupportLib.skip_field(pos, buf, wire_type);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("previous_field_number = field_number;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decoding must have consumed len bytes")
+	b.P("if (pos != initial_pos + len) {")
+	b.Indent()
+	b.P("return (false, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("return (true, false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateAccessorHeader generates the signatures of an accessor and the
+// search for its field. The generated code leaves the position of the field's
+// value in pos, and the default value in v.
+func generateAccessorHeader(name string, fieldNumber int32, returnType string, b *WriteableBuffer) {
+	b.P(fmt.Sprintf("function get_%s(bytes memory buf) internal pure returns (bool, %s) {", name, returnType))
+	b.Indent()
+	b.P(fmt.Sprintf("return get_%s(0, buf, uint64(buf.length));", name))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function get_%s(uint64 initial_pos, bytes memory buf, uint64 len) internal pure returns (bool, %s) {", name, returnType))
+	b.Indent()
+	b.P(fmt.Sprintf("%s v;", returnType))
+	b.P()
+
+	b.P("bool success;")
+	b.P("bool found;")
+	b.P("uint64 pos;")
+	b.P(fmt.Sprintf("(success, found, pos) = find_field(initial_pos, buf, len, %d);", fieldNumber))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Absent field has the default value")
+	b.P("if (!found) {")
+	b.Indent()
+	b.P("return (true, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateAccessorValue generates code that decodes a singular field's value at
+// pos into v. On failure, the generated code returns fail.
+func (g *Generator) generateAccessorValue(structName string, field *descriptorpb.FieldDescriptorProto, fail string, b *WriteableBuffer) error {
+	fieldDescriptorType := field.GetType()
+
+	switch fieldDescriptorType {
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P("int32 e;")
+		b.P("(success, pos, e) = ProtobufLib.decode_enum(pos, buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Default value must be omitted")
+		b.P("if (e == 0) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Check that value is within enum range")
+		b.P(fmt.Sprintf("if (e < 0 || e > %d) {", g.enumMaxes[fieldTypeName]))
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P(fmt.Sprintf("v = %s(e);", fieldTypeName))
+		b.P()
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P("uint64 nested_len;")
+		b.P("(success, pos, nested_len) = ProtobufLib.decode_embedded_message(pos, buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if !g.isFieldWrapper(field) {
+			b.P("// Default value must be omitted")
+			b.P("if (nested_len == 0) {")
+			b.Indent()
+			b.P(fail)
+			b.Unindent()
+			b.P("}")
+			b.P()
+		}
+
+		b.P(fmt.Sprintf("(success, pos, v) = %sCodec.decode(pos, buf, nested_len);", fieldTypeName))
+		b.P("if (!success) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		b.P("uint64 field_len;")
+		b.P("(success, pos, field_len) = ProtobufLib.decode_length_delimited(pos, buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Default value must be omitted")
+		b.P("if (field_len == 0) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_STRING {
+			b.P("v = string(ProtobufSupportLib.slice(buf, pos, field_len));")
+		} else {
+			b.P("v = ProtobufSupportLib.slice(buf, pos, field_len);")
+		}
+
+		b.P("pos = pos + field_len;")
+		b.P()
+	default:
+		fieldDecodeType, err := typeToDecodeSol(fieldDescriptorType)
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+
+		b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
+		b.P("if (!success) {")
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Default value must be omitted")
+		switch fieldDescriptorType {
+		case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+			b.P("if (v == false) {")
+		default:
+			b.P("if (v == 0) {")
+		}
+		b.Indent()
+		b.P(fail)
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+
+	return nil
+}
+
+// toSolReturnType returns the Solidity type used to return a singular field.
+func toSolReturnType(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return toSolMessageOrEnumName(field)
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return "", err
+		}
+		return fieldTypeName + " memory", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		fieldType, err := typeToSol(field.GetType())
+		if err != nil {
+			return "", err
+		}
+		return fieldType + " memory", nil
+	}
+
+	return typeToSol(field.GetType())
+}
@@ -0,0 +1,477 @@
+package generator
+
+// decoderInput describes where a generated decoder reads its input from.
+type decoderInput struct {
+	// Suffix appended to the names of generated functions
+	suffix string
+	// Solidity type of the input buffer
+	bufType string
+	// Library used to decode primitive types
+	lib string
+}
+
+var (
+	memoryInput   = decoderInput{"", "bytes memory", "ProtobufLib"}
+	calldataInput = decoderInput{"_calldata", "bytes calldata", calldataLibName}
+)
+
+// decoderInputs returns the inputs decoders are generated for.
+func (g *Generator) decoderInputs() []decoderInput {
+	switch g.inputFlag {
+	case inputFlagAll:
+		return []decoderInput{memoryInput, calldataInput}
+	case inputFlagCalldata:
+		return []decoderInput{calldataInput}
+	}
+	return []decoderInput{memoryInput}
+}
+
+// isCalldataDecoded returns true if decoders reading from calldata are generated.
+func (g *Generator) isCalldataDecoded() bool {
+	return (g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder) &&
+		(g.inputFlag == inputFlagAll || g.inputFlag == inputFlagCalldata)
+}
+
+const calldataLibName = "ProtobufCalldataLib"
+
+// calldataLibSource is a port of the ProtobufLib decoding functions that reads
+// from calldata, with the same strictness rules.
+const calldataLibSource = `library ProtobufCalldataLib {
+    /// @dev Maximum number of bytes for a varint.
+    /// @dev 64 bits, in groups of base-128 (7 bits).
+    uint64 internal constant MAX_VARINT_BYTES = 10;
+
+    function decode_key(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64,
+            ProtobufLib.WireType
+        )
+    {
+        // The key is a varint with encoding
+        // (field_number << 3) | wire_type
+        (bool success, uint64 pos, uint64 key) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0, ProtobufLib.WireType.Varint);
+        }
+
+        uint64 field_number = key >> 3;
+        uint64 wire_type_val = key & 0x07;
+        // Check that wire type is bounded
+        if (wire_type_val > uint64(ProtobufLib.WireType.Bits32)) {
+            return (false, pos, 0, ProtobufLib.WireType.Varint);
+        }
+        ProtobufLib.WireType wire_type = ProtobufLib.WireType(wire_type_val);
+
+        // Start and end group types are deprecated, so forbid them
+        if (wire_type == ProtobufLib.WireType.StartGroup || wire_type == ProtobufLib.WireType.EndGroup) {
+            return (false, pos, 0, ProtobufLib.WireType.Varint);
+        }
+
+        return (true, pos, field_number, wire_type);
+    }
+
+    function decode_varint(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        uint64 val;
+        uint64 i;
+
+        for (i = 0; i < MAX_VARINT_BYTES; i++) {
+            // Check that index is within bounds
+            if (i + p >= buf.length) {
+                return (false, p, 0);
+            }
+
+            // Get byte at offset
+            uint8 b = uint8(buf[p + i]);
+
+            // Highest bit is used to indicate if there are more bytes to come
+            // Mask to get 7-bit value: 0111 1111
+            uint8 v = b & 0x7F;
+
+            // Groups of 7 bits are ordered least significant first
+            val |= uint64(v) << uint64(i * 7);
+
+            // Mask to get keep going bit: 1000 0000
+            if (b & 0x80 == 0) {
+                // [STRICT]
+                // Check for trailing zeroes if more than one byte is used
+                // (the value 0 still uses one byte)
+                if (i > 0 && v == 0) {
+                    return (false, p, 0);
+                }
+
+                break;
+            }
+        }
+
+        // Check that at most MAX_VARINT_BYTES are used
+        if (i >= MAX_VARINT_BYTES) {
+            return (false, p, 0);
+        }
+
+        // [STRICT]
+        // If all 10 bytes are used, the last byte (most significant 7 bits)
+        // must be at most 0000 0001, since 7*9 = 63
+        if (i == MAX_VARINT_BYTES - 1) {
+            if (uint8(buf[p + i]) > 1) {
+                return (false, p, 0);
+            }
+        }
+
+        return (true, p + i + 1, val);
+    }
+
+    function decode_int32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int32
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // [STRICT]
+        // Negative values are sign-extended to 64 bits, so the value must be
+        // within int32 range when interpreted as an int64
+        int64 signed = int64(val);
+        if (signed < type(int32).min || signed > type(int32).max) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, int32(signed));
+    }
+
+    function decode_int64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int64
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, int64(val));
+    }
+
+    function decode_uint32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint32
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // [STRICT] Check that value is within uint32 range
+        if (val > type(uint32).max) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, uint32(val));
+    }
+
+    function decode_uint64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        return decode_varint(p, buf);
+    }
+
+    function decode_sint32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int32
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // [STRICT] Check that value is within uint32 range
+        if (val > type(uint32).max) {
+            return (false, pos, 0);
+        }
+
+        // Zigzag decoding
+        int64 signed = int64(val >> 1) ^ -int64(val & 1);
+
+        return (true, pos, int32(signed));
+    }
+
+    function decode_sint64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int64
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Zigzag decoding
+        int64 signed = int64(val >> 1) ^ -int64(val & 1);
+
+        return (true, pos, signed);
+    }
+
+    function decode_bool(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            bool
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, false);
+        }
+
+        // [STRICT] Check that value is 0 or 1
+        if (val > 1) {
+            return (false, pos, false);
+        }
+
+        return (true, pos, val == 1);
+    }
+
+    function decode_enum(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int32
+        )
+    {
+        return decode_int32(p, buf);
+    }
+
+    function decode_bits64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        // Check that index is within bounds
+        if (p + 8 > buf.length) {
+            return (false, p, 0);
+        }
+
+        // Little-endian
+        uint64 val;
+        for (uint64 i = 0; i < 8; i++) {
+            val |= uint64(uint8(buf[p + i])) << uint64(i * 8);
+        }
+
+        return (true, p + 8, val);
+    }
+
+    function decode_fixed64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        return decode_bits64(p, buf);
+    }
+
+    function decode_sfixed64(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int64
+        )
+    {
+        (bool success, uint64 pos, uint64 val) = decode_bits64(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, int64(val));
+    }
+
+    function decode_bits32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint32
+        )
+    {
+        // Check that index is within bounds
+        if (p + 4 > buf.length) {
+            return (false, p, 0);
+        }
+
+        // Little-endian
+        uint32 val;
+        for (uint64 i = 0; i < 4; i++) {
+            val |= uint32(uint8(buf[p + i])) << uint32(i * 8);
+        }
+
+        return (true, p + 4, val);
+    }
+
+    function decode_fixed32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint32
+        )
+    {
+        return decode_bits32(p, buf);
+    }
+
+    function decode_sfixed32(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            int32
+        )
+    {
+        (bool success, uint64 pos, uint32 val) = decode_bits32(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, int32(val));
+    }
+
+    function decode_length_delimited(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        (bool success, uint64 pos, uint64 size) = decode_varint(p, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Check for overflow
+        if (pos + size < pos) {
+            return (false, pos, 0);
+        }
+
+        // Check that index is within bounds
+        if (size + pos > buf.length) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, size);
+    }
+
+    function decode_string(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            string memory
+        )
+    {
+        (bool success, uint64 pos, uint64 size) = decode_length_delimited(p, buf);
+        if (!success) {
+            return (false, pos, "");
+        }
+
+        // Copy the slice out of calldata
+        bytes memory field = buf[pos:pos + size];
+
+        return (true, pos + size, string(field));
+    }
+
+    function decode_bytes(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        return decode_length_delimited(p, buf);
+    }
+
+    function decode_embedded_message(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        return decode_length_delimited(p, buf);
+    }
+
+    function decode_packed_repeated(uint64 p, bytes calldata buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        return decode_length_delimited(p, buf);
+    }
+}
+`
@@ -0,0 +1,286 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// eip712Member is a member of an EIP-712 struct type, as in the types of typed
+// data JSON.
+type eip712Member struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// eip712DomainMembers are the members of the EIP712Domain type used by the
+// domain_separator function of the support library.
+var eip712DomainMembers = []eip712Member{
+	{"name", "string"},
+	{"version", "string"},
+	{"chainId", "uint256"},
+	{"verifyingContract", "address"},
+}
+
+// toEIP712Type returns the EIP-712 type of a field. Enums are encoded as their
+// Solidity ABI type, uint8, and zigzag and fixed-size encodings don't change
+// the type of the value.
+func toEIP712Type(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	arrayStr := ""
+	if isFieldRepeated(field) {
+		arrayStr = "[]"
+	}
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return "uint8" + arrayStr, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return "", err
+		}
+		return fieldTypeName + arrayStr, nil
+	}
+
+	fieldType, err := typeToSol(field.GetType())
+	if err != nil {
+		return "", err
+	}
+	return fieldType + arrayStr, nil
+}
+
+// eip712Members returns the members of the EIP-712 type of a message.
+func (g *Generator) eip712Members(structName string) ([]eip712Member, error) {
+	descriptor, ok := g.messages[structName]
+	if !ok {
+		return nil, errors.New("unknown message: " + structName)
+	}
+
+	members := []eip712Member{}
+	for _, field := range descriptor.GetField() {
+		fieldType, err := toEIP712Type(field)
+		if err != nil {
+			return nil, errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+		members = append(members, eip712Member{g.protoFieldName(structName, field), fieldType})
+	}
+
+	return members, nil
+}
+
+// eip712Dependencies adds the messages a message embeds, directly or not, to
+// dependencies. Recursive messages are only added once.
+func (g *Generator) eip712Dependencies(structName string, dependencies map[string]bool) error {
+	descriptor, ok := g.messages[structName]
+	if !ok {
+		return errors.New("unknown message: " + structName)
+	}
+
+	for _, field := range descriptor.GetField() {
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		if dependencies[fieldTypeName] {
+			continue
+		}
+		dependencies[fieldTypeName] = true
+
+		err = g.eip712Dependencies(fieldTypeName, dependencies)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eip712TypeString returns the encoded EIP-712 type of a message: its own type
+// followed by the types it references, sorted by name.
+func (g *Generator) eip712TypeString(structName string) (string, error) {
+	dependencies := make(map[string]bool)
+	err := g.eip712Dependencies(structName, dependencies)
+	if err != nil {
+		return "", err
+	}
+	// A recursive message is only encoded as the primary type
+	delete(dependencies, structName)
+
+	names := []string{}
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append([]string{structName}, names...)
+
+	typeString := ""
+	for _, name := range names {
+		members, err := g.eip712Members(name)
+		if err != nil {
+			return "", err
+		}
+
+		memberStrings := []string{}
+		for _, member := range members {
+			memberStrings = append(memberStrings, member.Type+" "+member.Name)
+		}
+		typeString += name + "(" + strings.Join(memberStrings, ",") + ")"
+	}
+
+	return typeString, nil
+}
+
+// generateEIP712Functions generates the EIP-712 type of a message and the
+// functions of its codec library that hash instances as typed data.
+func (g *Generator) generateEIP712Functions(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	typeString, err := g.eip712TypeString(structName)
+	if err != nil {
+		return err
+	}
+
+	b.P("// EIP-712 type of the message")
+	b.P(fmt.Sprintf("string constant TYPE_STRING = \"%s\";", typeString))
+	b.P(fmt.Sprintf("bytes32 constant TYPEHASH = keccak256(\"%s\");", typeString))
+	b.P()
+
+	b.P("// EIP-712 hash of instance")
+	b.P(fmt.Sprintf("function hash_struct(%s memory instance) internal pure returns (bytes32) {", structName))
+	b.Indent()
+	b.P("// Members are encoded as 32-byte words, after the type hash")
+	b.P(fmt.Sprintf("bytes32[] memory words = new bytes32[](%d);", len(fields)+1))
+	b.P("words[0] = TYPEHASH;")
+	for i, field := range fields {
+		err := g.generateEIP712Member(structName, field, fmt.Sprintf("words[%d]", i+1), b)
+		if err != nil {
+			return err
+		}
+	}
+	b.P()
+	b.P("return keccak256(abi.encodePacked(words));")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// EIP-712 digest of instance in a domain, which is signed")
+	b.P(fmt.Sprintf("function typed_data_hash(%s memory instance, bytes32 separator) internal pure returns (bytes32) {", structName))
+	b.Indent()
+	b.P("return ProtobufSupportLib.typed_data_hash(separator, hash_struct(instance));")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateEIP712Member generates code that sets word to the EIP-712 encoding
+// of a field's value.
+func (g *Generator) generateEIP712Member(structName string, field *descriptorpb.FieldDescriptorProto, word string, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+	v := "instance." + fieldName
+
+	if isFieldRepeated(field) {
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			// Elements of packed arrays are padded to 32 bytes
+			b.P(fmt.Sprintf("%s = keccak256(abi.encodePacked(%s));", word, v))
+			return nil
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P("{")
+		b.Indent()
+		b.P(fmt.Sprintf("bytes32[] memory hashes = new bytes32[](%s.length);", v))
+		b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+		b.Indent()
+		b.P(fmt.Sprintf("hashes[i] = %sCodec.hash_struct(%s[i]);", fieldTypeName, v))
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("%s = keccak256(abi.encodePacked(hashes));", word))
+		b.Unindent()
+		b.P("}")
+		return nil
+	}
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		b.P(fmt.Sprintf("%s = %sCodec.hash_struct(%s);", word, fieldTypeName, v))
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		if g.isFieldView(structName, field) {
+			b.P(fmt.Sprintf("%s = ProtobufSupportLib.hash(%s);", word, v))
+		} else {
+			b.P(fmt.Sprintf("%s = keccak256(bytes(%s));", word, v))
+		}
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		b.P(fmt.Sprintf("%s = bytes32(uint256(%s ? 1 : 0));", word, v))
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		// Signed values are sign-extended
+		b.P(fmt.Sprintf("%s = bytes32(uint256(int256(%s)));", word, v))
+	default:
+		b.P(fmt.Sprintf("%s = bytes32(uint256(%s));", word, v))
+	}
+
+	return nil
+}
+
+// generateEIP712Schema generates the EIP-712 types of the messages of a .proto
+// file and the messages they embed, in the format of the types of typed data
+// JSON, for front-ends.
+func (g *Generator) generateEIP712Schema(protoFile *descriptorpb.FileDescriptorProto) (*pluginpb.CodeGeneratorResponse_File, error) {
+	types := map[string][]eip712Member{
+		"EIP712Domain": eip712DomainMembers,
+	}
+
+	dependencies := make(map[string]bool)
+	for _, descriptor := range fileMessages(protoFile) {
+		dependencies[descriptor.GetName()] = true
+
+		err := g.eip712Dependencies(descriptor.GetName(), dependencies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for name := range dependencies {
+		members, err := g.eip712Members(name)
+		if err != nil {
+			return nil, err
+		}
+		types[name] = members
+	}
+
+	// Keys of maps are sorted, so the output is deterministic
+	content, err := json.MarshalIndent(map[string]interface{}{"types": types}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	responseFile := &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(filepath.Base(protoFile.GetName()) + ".eip712.json"),
+		Content: proto.String(string(content) + "\n"),
+	}
+
+	return responseFile, nil
+}
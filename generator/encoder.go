@@ -0,0 +1,696 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Generate encoder
+func (g *Generator) generateMessageEncoder(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	////////////////////////////////////
+	// Generate top-level functions
+	////////////////////////////////////
+
+	b.P(fmt.Sprintf("function encode(%s memory instance) internal pure returns (bytes memory) {", structName))
+	b.Indent()
+	b.P("// Compute the sizes of instance and its nested messages once, then encode in place")
+	b.P("uint64[] memory sizes = cached_sizes(instance);")
+	b.P("bytes memory buf = new bytes(sizes[0]);")
+	b.P("encode_at(0, buf, instance, sizes);")
+	b.P()
+	b.P("return buf;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Number of bytes needed to encode instance")
+	b.P(fmt.Sprintf("function encoded_size(%s memory instance) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("uint64 len = 0;")
+	for _, field := range fields {
+		b.P(fmt.Sprintf("len += size_%d(instance);", field.GetNumber()))
+	}
+	b.P()
+	b.P("return len;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Encode instance into buf at pos, which must have enough bytes, returns the new pos")
+	b.P(fmt.Sprintf("function encode_at(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("return encode_at(pos, buf, instance, cached_sizes(instance));")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Sizes of instance, first, and of its nested messages, to encode instance with")
+	b.P(fmt.Sprintf("function cached_sizes(%s memory instance) internal pure returns (uint64[] memory) {", structName))
+	b.Indent()
+	b.P("uint64[] memory sizes = new uint64[](message_count(instance));")
+	b.P("cache_sizes(instance, sizes, 0);")
+	b.P()
+	b.P("return sizes;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Encode instance into buf at pos with its cached sizes, which must have enough bytes, returns the new pos")
+	b.P(fmt.Sprintf("function encode_at(uint64 pos, bytes memory buf, %s memory instance, uint64[] memory sizes) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("(pos, ) = encode_cached(pos, buf, instance, sizes, 0);")
+	b.P()
+	b.P("return pos;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	////////////////////////////////////
+	// Generate size cache functions
+	////////////////////////////////////
+
+	// The size of each message is cached in pre-order, so that encoding
+	// doesn't compute the size of a nested message again at every level.
+	b.P("// Number of messages in instance, including itself, each of which has a cached size")
+	b.P(fmt.Sprintf("function message_count(%s memory instance) internal pure returns (uint256) {", structName))
+	b.Indent()
+	b.P("uint256 count = 1;")
+	for _, field := range fields {
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		v := fmt.Sprintf("instance.%s", field.GetName())
+
+		if isFieldRepeated(field) {
+			b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+			b.Indent()
+			b.P(fmt.Sprintf("count += %sCodec.message_count(%s[i]);", fieldTypeName, v))
+			b.Unindent()
+			b.P("}")
+		} else {
+			b.P(fmt.Sprintf("count += %sCodec.message_count(%s);", fieldTypeName, v))
+		}
+	}
+	b.P()
+	b.P("return count;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Cache the sizes of instance and its nested messages from index i, returns the next index")
+	b.P(fmt.Sprintf("function cache_sizes(%s memory instance, uint64[] memory sizes, uint256 i) internal pure returns (uint256) {", structName))
+	b.Indent()
+	b.P("uint256 index = i;")
+	b.P("i++;")
+	b.P()
+	b.P("uint64 len = 0;")
+	if hasMessageField(fields) {
+		b.P("uint64 field_len;")
+	}
+	for _, field := range fields {
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			b.P(fmt.Sprintf("(field_len, i) = cache_size_%d(instance, sizes, i);", field.GetNumber()))
+			b.P("len += field_len;")
+		} else {
+			b.P(fmt.Sprintf("len += size_%d(instance);", field.GetNumber()))
+		}
+	}
+	b.P("sizes[index] = len;")
+	b.P()
+	b.P("return i;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Encode instance into buf at pos with the sizes cached from index i, returns the new pos and the next index")
+	b.P(fmt.Sprintf("function encode_cached(uint64 pos, bytes memory buf, %s memory instance, uint64[] memory sizes, uint256 i) internal pure returns (uint64, uint256) {", structName))
+	b.Indent()
+	b.P("// Skip the size of instance itself")
+	b.P("i++;")
+	b.P()
+	for _, field := range fields {
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			b.P(fmt.Sprintf("(pos, i) = encode_%d(pos, buf, instance, sizes, i);", field.GetNumber()))
+		} else {
+			b.P(fmt.Sprintf("pos = encode_%d(pos, buf, instance);", field.GetNumber()))
+		}
+	}
+	if len(fields) > 0 {
+		b.P()
+	}
+	b.P("return (pos, i);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	////////////////////////////////////
+	// Generate individual field sizes and encoders
+	////////////////////////////////////
+
+	for _, field := range fields {
+		fieldName := field.GetName()
+		fieldNumber := field.GetNumber()
+
+		key, err := toKey(field)
+		if err != nil {
+			return err
+		}
+		keySize := varintSize(key)
+
+		b.P(fmt.Sprintf("// %s.%s", structName, fieldName))
+		b.P(fmt.Sprintf("function size_%d(%s memory instance) internal pure returns (uint64) {", fieldNumber, structName))
+		b.Indent()
+		err = g.generateFieldSize(structName, field, fmt.Sprintf("instance.%s", fieldName), keySize, b)
+		if err != nil {
+			return err
+		}
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			err = g.generateCachedMessageField(structName, field, key, b)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		b.P(fmt.Sprintf("function encode_%d(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (uint64) {", fieldNumber, structName))
+		b.Indent()
+		err = g.generateFieldEncoder(structName, field, fmt.Sprintf("instance.%s", fieldName), key, b)
+		if err != nil {
+			return err
+		}
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+
+	return nil
+}
+
+// generateCachedMessageField generates the functions that cache the sizes of
+// an embedded message field and encode it with them.
+func (g *Generator) generateCachedMessageField(structName string, field *descriptorpb.FieldDescriptorProto, key uint64, b *WriteableBuffer) error {
+	fieldNumber := field.GetNumber()
+	v := fmt.Sprintf("instance.%s", field.GetName())
+	keySize := varintSize(key)
+
+	fieldTypeName, err := toSolMessageOrEnumName(field)
+	if err != nil {
+		return err
+	}
+
+	b.P(fmt.Sprintf("function cache_size_%d(%s memory instance, uint64[] memory sizes, uint256 i) internal pure returns (uint64, uint256) {", fieldNumber, structName))
+	b.Indent()
+	if isFieldRepeated(field) {
+		// Repeated message, each element is wrapped in key and length
+		b.P("uint64 len = 0;")
+		b.P(fmt.Sprintf("for (uint256 j = 0; j < %s.length; j++) {", v))
+		b.Indent()
+		b.P("uint256 index = i;")
+		b.P(fmt.Sprintf("i = %sCodec.cache_sizes(%s[j], sizes, i);", fieldTypeName, v))
+		b.P(fmt.Sprintf("len += %d + ProtobufSupportLib.size_varint(sizes[index]) + sizes[index];", keySize))
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return (len, i);")
+	} else {
+		b.P("uint256 index = i;")
+		b.P(fmt.Sprintf("i = %sCodec.cache_sizes(%s, sizes, i);", fieldTypeName, v))
+		b.P("uint64 len = sizes[index];")
+		b.P()
+		generateOmittedMessageCheck(g.isFieldWrapper(field), v, "(0, i)", b)
+		b.P(fmt.Sprintf("return (%d + ProtobufSupportLib.size_varint(len) + len, i);", keySize))
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function encode_%d(uint64 pos, bytes memory buf, %s memory instance, uint64[] memory sizes, uint256 i) internal pure returns (uint64, uint256) {", fieldNumber, structName))
+	b.Indent()
+	if isFieldRepeated(field) {
+		b.P(fmt.Sprintf("for (uint256 j = 0; j < %s.length; j++) {", v))
+		b.Indent()
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P("pos = ProtobufSupportLib.write_varint(pos, buf, sizes[i]);")
+		b.P(fmt.Sprintf("(pos, i) = %sCodec.encode_cached(pos, buf, %s[j], sizes, i);", fieldTypeName, v))
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return (pos, i);")
+	} else {
+		b.P("uint64 len = sizes[i];")
+		b.P()
+		generateOmittedMessageCheck(g.isFieldWrapper(field), v, fmt.Sprintf("(pos, i + %sCodec.message_count(%s))", fieldTypeName, v), b)
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P("pos = ProtobufSupportLib.write_varint(pos, buf, len);")
+		b.P(fmt.Sprintf("return %sCodec.encode_cached(pos, buf, %s, sizes, i);", fieldTypeName, v))
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// hasMessageField returns whether any of fields is an embedded message.
+func hasMessageField(fields []*descriptorpb.FieldDescriptorProto) bool {
+	for _, field := range fields {
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			return true
+		}
+	}
+	return false
+}
+
+// generateOmittedMessageCheck generates code that returns ret if an embedded
+// message, given by the expression v and of encoded size len, is omitted.
+func generateOmittedMessageCheck(isWrapper bool, v string, ret string, b *WriteableBuffer) {
+	if isWrapper {
+		// Wrappers carry presence, so are encoded even with the default value
+		b.P("// Absent value is omitted")
+		b.P(fmt.Sprintf("if (!%s.present) {", v))
+	} else {
+		b.P("// Default value is omitted")
+		b.P("if (len == 0) {")
+	}
+	b.Indent()
+	b.P(fmt.Sprintf("return %s;", ret))
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateFieldSize generates code that returns the number of bytes needed to
+// encode a field, whose value is given by the expression v.
+func (g *Generator) generateFieldSize(structName string, field *descriptorpb.FieldDescriptorProto, v string, keySize int, b *WriteableBuffer) error {
+	fieldDescriptorType := field.GetType()
+
+	if isFieldRepeated(field) {
+		if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			// Repeated message, each element is wrapped in key and length
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+
+			b.P("uint64 len = 0;")
+			b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+			b.Indent()
+			b.P(fmt.Sprintf("uint64 nested_len = %sCodec.encoded_size(%s[i]);", fieldTypeName, v))
+			b.P(fmt.Sprintf("len += %d + ProtobufSupportLib.size_varint(nested_len) + nested_len;", keySize))
+			b.Unindent()
+			b.P("}")
+			b.P()
+			b.P("return len;")
+
+			return nil
+		}
+
+		// Packed repeated numeric
+		b.P("// Empty packed array is omitted")
+		b.P(fmt.Sprintf("if (%s.length == 0) {", v))
+		b.Indent()
+		b.P("return 0;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if g.isHelperUsed(field) {
+			kind, err := toHelperKind(field)
+			if err != nil {
+				return err
+			}
+			b.P(fmt.Sprintf("uint64 len = %s.size_packed_%s(%s);", g.helperLibName, kind, v))
+			b.P()
+		} else {
+			err := g.generatePackedSize(structName, field, v, b)
+			if err != nil {
+				return err
+			}
+		}
+		b.P(fmt.Sprintf("return %d + ProtobufSupportLib.size_varint(len) + len;", keySize))
+
+		return nil
+	}
+
+	switch fieldDescriptorType {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P(fmt.Sprintf("uint64 len = %sCodec.encoded_size(%s);", fieldTypeName, v))
+		b.P()
+		generateOmittedMessageCheck(g.isFieldWrapper(field), v, "0", b)
+		b.P(fmt.Sprintf("return %d + ProtobufSupportLib.size_varint(len) + len;", keySize))
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		if g.isFieldView(structName, field) {
+			b.P(fmt.Sprintf("uint64 len = %s.length;", v))
+		} else {
+			b.P(fmt.Sprintf("uint64 len = uint64(bytes(%s).length);", v))
+		}
+		b.P()
+		b.P("// Default value is omitted")
+		b.P("if (len == 0) {")
+		b.Indent()
+		b.P("return 0;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P(fmt.Sprintf("return %d + ProtobufSupportLib.size_varint(len) + len;", keySize))
+	default:
+		generateDefaultCheck(field, v, "0", b)
+
+		valueSize, err := toSolValueSize(field, v)
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+		b.P(fmt.Sprintf("return %d + %s;", keySize, valueSize))
+	}
+
+	return nil
+}
+
+// generatePackedSize generates code that computes the number of bytes of a
+// packed repeated field's elements, whose value is given by the expression v,
+// into len.
+func (g *Generator) generatePackedSize(structName string, field *descriptorpb.FieldDescriptorProto, v string, b *WriteableBuffer) error {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		b.P(fmt.Sprintf("uint64 len = uint64(%s.length) * 4;", v))
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		b.P(fmt.Sprintf("uint64 len = uint64(%s.length) * 8;", v))
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		b.P(fmt.Sprintf("uint64 len = uint64(%s.length);", v))
+	default:
+		valueSize, err := toSolValueSize(field, v+"[i]")
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+
+		b.P("uint64 len = 0;")
+		b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+		b.Indent()
+		b.P(fmt.Sprintf("len += %s;", valueSize))
+		b.Unindent()
+		b.P("}")
+	}
+	b.P()
+
+	return nil
+}
+
+// generateFieldEncoder generates code that encodes a field, whose value is
+// given by the expression v, into buf at pos and returns the new pos.
+func (g *Generator) generateFieldEncoder(structName string, field *descriptorpb.FieldDescriptorProto, v string, key uint64, b *WriteableBuffer) error {
+	fieldDescriptorType := field.GetType()
+
+	if isFieldRepeated(field) {
+		if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			// Repeated message, each element is wrapped in key and length
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+
+			b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+			b.Indent()
+			b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+			b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %sCodec.encoded_size(%s[i]));", fieldTypeName, v))
+			b.P(fmt.Sprintf("pos = %sCodec.encode_at(pos, buf, %s[i]);", fieldTypeName, v))
+			b.Unindent()
+			b.P("}")
+			b.P()
+			b.P("return pos;")
+
+			return nil
+		}
+
+		// Packed repeated numeric
+		if g.isHelperUsed(field) {
+			kind, err := toHelperKind(field)
+			if err != nil {
+				return err
+			}
+			b.P(fmt.Sprintf("return %s.encode_packed_%s(pos, buf, %d, %s);", g.helperLibName, kind, key, v))
+
+			return nil
+		}
+
+		b.P("// Empty packed array is omitted")
+		b.P(fmt.Sprintf("if (%s.length == 0) {", v))
+		b.Indent()
+		b.P("return pos;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		err := g.generatePackedSize(structName, field, v, b)
+		if err != nil {
+			return err
+		}
+
+		valueWrite, err := toSolValueWrite(field, v+"[i]")
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P("pos = ProtobufSupportLib.write_varint(pos, buf, len);")
+		b.P(fmt.Sprintf("for (uint256 i = 0; i < %s.length; i++) {", v))
+		b.Indent()
+		b.P(fmt.Sprintf("pos = %s;", valueWrite))
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return pos;")
+
+		return nil
+	}
+
+	switch fieldDescriptorType {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P(fmt.Sprintf("uint64 len = %sCodec.encoded_size(%s);", fieldTypeName, v))
+		b.P()
+		generateOmittedMessageCheck(g.isFieldWrapper(field), v, "pos", b)
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P("pos = ProtobufSupportLib.write_varint(pos, buf, len);")
+		b.P(fmt.Sprintf("return %sCodec.encode_at(pos, buf, %s);", fieldTypeName, v))
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		if g.isFieldView(structName, field) {
+			b.P(fmt.Sprintf("uint64 len = %s.length;", v))
+		} else {
+			b.P(fmt.Sprintf("uint64 len = uint64(bytes(%s).length);", v))
+		}
+		b.P()
+		b.P("// Default value is omitted")
+		b.P("if (len == 0) {")
+		b.Indent()
+		b.P("return pos;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P("pos = ProtobufSupportLib.write_varint(pos, buf, len);")
+		if g.isFieldView(structName, field) {
+			b.P(fmt.Sprintf("return ProtobufSupportLib.write_view(pos, buf, %s);", v))
+		} else {
+			b.P(fmt.Sprintf("return ProtobufSupportLib.write_bytes(pos, buf, bytes(%s));", v))
+		}
+	default:
+		generateDefaultCheck(field, v, "pos", b)
+
+		valueWrite, err := toSolValueWrite(field, v)
+		if err != nil {
+			return errors.New(err.Error() + ": " + structName + "." + field.GetName())
+		}
+		b.P(fmt.Sprintf("pos = ProtobufSupportLib.write_varint(pos, buf, %d);", key))
+		b.P(fmt.Sprintf("return %s;", valueWrite))
+	}
+
+	return nil
+}
+
+// generateDefaultCheck generates code that returns ret if a numeric, bool or
+// enum value, given by the expression v, is the default value.
+func generateDefaultCheck(field *descriptorpb.FieldDescriptorProto, v string, ret string, b *WriteableBuffer) {
+	b.P("// Default value is omitted")
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		b.P(fmt.Sprintf("if (%s == false) {", v))
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		b.P(fmt.Sprintf("if (uint64(%s) == 0) {", v))
+	default:
+		b.P(fmt.Sprintf("if (%s == 0) {", v))
+	}
+	b.Indent()
+	b.P(fmt.Sprintf("return %s;", ret))
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// toSolValueSize returns a Solidity expression for the number of bytes needed
+// to encode a numeric, bool or enum value, given by the expression v.
+func toSolValueSize(field *descriptorpb.FieldDescriptorProto, v string) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_INT64:
+		return fmt.Sprintf("ProtobufSupportLib.size_int64(%s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT64:
+		return fmt.Sprintf("ProtobufSupportLib.size_varint(%s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return fmt.Sprintf("ProtobufSupportLib.size_sint64(%s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "4", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return "8", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return "1", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return fmt.Sprintf("ProtobufSupportLib.size_varint(uint64(%s))", v), nil
+	}
+
+	return "", errors.New("unsupported field type " + field.GetType().String())
+}
+
+// toSolValueWrite returns a Solidity expression that encodes a numeric, bool or
+// enum value, given by the expression v, into buf at pos and evaluates to the
+// new pos.
+func toSolValueWrite(field *descriptorpb.FieldDescriptorProto, v string) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_INT64:
+		return fmt.Sprintf("ProtobufSupportLib.write_int64(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT64:
+		return fmt.Sprintf("ProtobufSupportLib.write_varint(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return fmt.Sprintf("ProtobufSupportLib.write_sint64(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return fmt.Sprintf("ProtobufSupportLib.write_bits32(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return fmt.Sprintf("ProtobufSupportLib.write_bits32(pos, buf, uint32(%s))", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return fmt.Sprintf("ProtobufSupportLib.write_bits64(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return fmt.Sprintf("ProtobufSupportLib.write_bits64(pos, buf, uint64(%s))", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return fmt.Sprintf("ProtobufSupportLib.write_bool(pos, buf, %s)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return fmt.Sprintf("ProtobufSupportLib.write_varint(pos, buf, uint64(%s))", v), nil
+	}
+
+	return "", errors.New("unsupported field type " + field.GetType().String())
+}
+
+// toKey returns the key (field number and wire type) of a field.
+func toKey(field *descriptorpb.FieldDescriptorProto) (uint64, error) {
+	wireType, err := toWireType(field)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(field.GetNumber())<<3 | wireType, nil
+}
+
+// toWireType returns the numeric wire type of a field.
+func toWireType(field *descriptorpb.FieldDescriptorProto) (uint64, error) {
+	wireStr, err := toSolWireType(field)
+	if err != nil {
+		return 0, err
+	}
+
+	switch wireStr {
+	case "ProtobufLib.WireType.Varint":
+		return 0, nil
+	case "ProtobufLib.WireType.Bits64":
+		return 1, nil
+	case "ProtobufLib.WireType.LengthDelimited":
+		return 2, nil
+	case "ProtobufLib.WireType.Bits32":
+		return 5, nil
+	}
+
+	return 0, errors.New("unsupported wire type: " + wireStr)
+}
+
+// varintSize returns the number of bytes needed to encode v as a varint.
+func varintSize(v uint64) int {
+	size := 1
+	for v >= 0x80 {
+		v >>= 7
+		size++
+	}
+	return size
+}
+
+// generateUncachedSizes generates the cached_sizes function and the encode_at
+// variant taking cached sizes for backends that don't cache the sizes of nested
+// messages, so that functions shared by all backends size messages only once.
+func generateUncachedSizes(structName string, b *WriteableBuffer) {
+	b.P("// Size of instance, to encode instance with")
+	b.P(fmt.Sprintf("function cached_sizes(%s memory instance) internal pure returns (uint64[] memory) {", structName))
+	b.Indent()
+	b.P("uint64[] memory sizes = new uint64[](1);")
+	b.P("sizes[0] = encoded_size(instance);")
+	b.P()
+	b.P("return sizes;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Encode instance into buf at pos with its cached sizes, which must have enough bytes, returns the new pos")
+	b.P(fmt.Sprintf("function encode_at(uint64 pos, bytes memory buf, %s memory instance, uint64[] memory) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("return encode_at(pos, buf, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateEncodeTo generates the encode_to function of a message's codec
+// library, which checks that a caller-provided buffer is large enough before
+// encoding into it.
+func generateEncodeTo(structName string, b *WriteableBuffer) {
+	b.P("// Encode instance into buf at offset, returns the offset after it")
+	b.P(fmt.Sprintf("function encode_to(%s memory instance, bytes memory buf, uint256 offset) internal pure returns (uint256) {", structName))
+	b.Indent()
+	b.P("uint64[] memory sizes = cached_sizes(instance);")
+	b.P()
+	b.P("// Check that buf has enough bytes after offset")
+	b.P("if (offset > buf.length || sizes[0] > buf.length - offset) {")
+	b.Indent()
+	b.P(fmt.Sprintf("revert(\"%s: buffer too small\");", structName))
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return encode_at(uint64(offset), buf, instance, sizes);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
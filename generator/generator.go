@@ -3,6 +3,7 @@ package generator
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"path/filepath"
 	"strings"
 
@@ -14,6 +15,14 @@ import (
 // SolidityVersionString is the Solidity version specifier.
 const SolidityVersionString = ">=0.6.0 <8.0.0"
 
+// SolidityCalldataVersionString is the Solidity version specifier for code that
+// passes calldata to internal functions.
+const SolidityCalldataVersionString = ">=0.6.9 <8.0.0"
+
+// SolidityCancunVersionString is the Solidity version specifier for code that
+// uses the MCOPY instruction.
+const SolidityCancunVersionString = ">=0.8.24 <8.0.0"
+
 // SolidityABIString indicates ABIEncoderV2 use.
 const SolidityABIString = "pragma experimental ABIEncoderV2;"
 
@@ -64,15 +73,197 @@ func toGenerateFlag(s string) (generateFlag, error) {
 	return generateFlagAll, fmt.Errorf("unknown generate flag %s, allowed values are <all, decoder, encoder>", s)
 }
 
+type inputFlag string
+
+const (
+	inputFlagAll      inputFlag = "all"
+	inputFlagMemory   inputFlag = "memory"
+	inputFlagCalldata inputFlag = "calldata"
+)
+
+func fromInputFlag(f inputFlag) string {
+	return string(f)
+}
+
+func toInputFlag(s string) (inputFlag, error) {
+	switch s {
+	case fromInputFlag(inputFlagAll):
+		return inputFlagAll, nil
+	case fromInputFlag(inputFlagMemory):
+		return inputFlagMemory, nil
+	case fromInputFlag(inputFlagCalldata):
+		return inputFlagCalldata, nil
+	}
+
+	return inputFlagMemory, fmt.Errorf("unknown input flag %s, allowed values are <all, memory, calldata>", s)
+}
+
+type evmFlag string
+
+const (
+	evmFlagDefault evmFlag = "default"
+	evmFlagCancun  evmFlag = "cancun"
+)
+
+func fromEVMFlag(f evmFlag) string {
+	return string(f)
+}
+
+func toEVMFlag(s string) (evmFlag, error) {
+	switch s {
+	case fromEVMFlag(evmFlagDefault):
+		return evmFlagDefault, nil
+	case fromEVMFlag(evmFlagCancun):
+		return evmFlagCancun, nil
+	}
+
+	return evmFlagDefault, fmt.Errorf("unknown evm flag %s, allowed values are <default, cancun>", s)
+}
+
+type optimizeFlag string
+
+const (
+	optimizeFlagGas  optimizeFlag = "gas"
+	optimizeFlagSize optimizeFlag = "size"
+)
+
+func fromOptimizeFlag(f optimizeFlag) string {
+	return string(f)
+}
+
+func toOptimizeFlag(s string) (optimizeFlag, error) {
+	switch s {
+	case fromOptimizeFlag(optimizeFlagGas):
+		return optimizeFlagGas, nil
+	case fromOptimizeFlag(optimizeFlagSize):
+		return optimizeFlagSize, nil
+	}
+
+	return optimizeFlagGas, fmt.Errorf("unknown optimize flag %s, allowed values are <gas, size>", s)
+}
+
+type backendFlag string
+
+const (
+	backendFlagSolidity backendFlag = "solidity"
+	backendFlagTable    backendFlag = "table"
+	backendFlagYul      backendFlag = "yul"
+)
+
+func fromBackendFlag(f backendFlag) string {
+	return string(f)
+}
+
+func toBackendFlag(s string) (backendFlag, error) {
+	switch s {
+	case fromBackendFlag(backendFlagSolidity):
+		return backendFlagSolidity, nil
+	case fromBackendFlag(backendFlagTable):
+		return backendFlagTable, nil
+	case fromBackendFlag(backendFlagYul):
+		return backendFlagYul, nil
+	}
+
+	return backendFlagSolidity, fmt.Errorf("unknown backend flag %s, allowed values are <solidity, table, yul>", s)
+}
+
+type layoutFlag string
+
+const (
+	layoutFlagDefault layoutFlag = "default"
+	layoutFlagPacked  layoutFlag = "packed"
+)
+
+func fromLayoutFlag(f layoutFlag) string {
+	return string(f)
+}
+
+func toLayoutFlag(s string) (layoutFlag, error) {
+	switch s {
+	case fromLayoutFlag(layoutFlagDefault):
+		return layoutFlagDefault, nil
+	case fromLayoutFlag(layoutFlagPacked):
+		return layoutFlagPacked, nil
+	}
+
+	return layoutFlagDefault, fmt.Errorf("unknown layout flag %s, allowed values are <default, packed>", s)
+}
+
+type hashFlag string
+
+const (
+	hashFlagNone      hashFlag = "none"
+	hashFlagKeccak256 hashFlag = "keccak256"
+	hashFlagSHA256    hashFlag = "sha256"
+	hashFlagAll       hashFlag = "all"
+)
+
+func fromHashFlag(f hashFlag) string {
+	return string(f)
+}
+
+func toHashFlag(s string) (hashFlag, error) {
+	switch s {
+	case fromHashFlag(hashFlagNone):
+		return hashFlagNone, nil
+	case fromHashFlag(hashFlagKeccak256):
+		return hashFlagKeccak256, nil
+	case fromHashFlag(hashFlagSHA256):
+		return hashFlagSHA256, nil
+	case fromHashFlag(hashFlagAll):
+		return hashFlagAll, nil
+	}
+
+	return hashFlagNone, fmt.Errorf("unknown hash flag %s, allowed values are <none, keccak256, sha256, all>", s)
+}
+
+func toBoolFlag(key string, s string) (bool, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown %s flag %s, allowed values are <true, false>", key, s)
+}
+
 // Generator generates Solidity code from .proto files.
 type Generator struct {
 	request   *pluginpb.CodeGeneratorRequest
 	enumMaxes map[string]int
+	messages  map[string]*descriptorpb.DescriptorProto
+	packages  map[string]string
 
 	versionString string
 	licenseString string
 	compileFlag   compileFlag
 	generateFlag  generateFlag
+	inputFlag     inputFlag
+	evmFlag       evmFlag
+	optimizeFlag  optimizeFlag
+	backendFlag   backendFlag
+	layoutFlag    layoutFlag
+	hashFlag      hashFlag
+	hashInPlace   bool
+	merkle        bool
+	eip712        bool
+	registry      bool
+	accessors     bool
+	iterators     bool
+	verifiers     bool
+	storage       bool
+	partial       bool
+
+	// String and bytes fields decoded as views, by <Message>.<field>
+	viewAll bool
+	views   map[string]bool
+
+	limits        decodeLimits
+	messageLimits map[string]decodeLimits
+
+	// Name of the helper library of the file being generated
+	helperLibName string
 }
 
 // New initializes a new Generator.
@@ -81,12 +272,23 @@ func New(request *pluginpb.CodeGeneratorRequest, versionString string) *Generato
 
 	g.request = request
 	g.enumMaxes = make(map[string]int)
+	g.messages = make(map[string]*descriptorpb.DescriptorProto)
+	g.packages = make(map[string]string)
 
 	g.versionString = versionString
 	g.licenseString = "CC0"
 
 	g.compileFlag = compileFlagCompile
 	g.generateFlag = generateFlagDecoder
+	g.inputFlag = inputFlagMemory
+	g.evmFlag = evmFlagDefault
+	g.optimizeFlag = optimizeFlagGas
+	g.backendFlag = backendFlagSolidity
+	g.layoutFlag = layoutFlagDefault
+	g.hashFlag = hashFlagNone
+
+	g.messageLimits = make(map[string]decodeLimits)
+	g.views = make(map[string]bool)
 
 	return g
 }
@@ -123,8 +325,109 @@ func (g *Generator) ParseParameters() error {
 				return err
 			}
 			g.generateFlag = flag
+		case "input":
+			flag, err := toInputFlag(value)
+			if err != nil {
+				return err
+			}
+			g.inputFlag = flag
+		case "evm":
+			flag, err := toEVMFlag(value)
+			if err != nil {
+				return err
+			}
+			g.evmFlag = flag
+		case "optimize":
+			flag, err := toOptimizeFlag(value)
+			if err != nil {
+				return err
+			}
+			g.optimizeFlag = flag
+		case "backend":
+			flag, err := toBackendFlag(value)
+			if err != nil {
+				return err
+			}
+			g.backendFlag = flag
+		case "layout":
+			flag, err := toLayoutFlag(value)
+			if err != nil {
+				return err
+			}
+			g.layoutFlag = flag
+		case "hash":
+			flag, err := toHashFlag(value)
+			if err != nil {
+				return err
+			}
+			g.hashFlag = flag
+		case "hash_in_place":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.hashInPlace = flag
+		case "merkle":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.merkle = flag
+		case "eip712":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.eip712 = flag
+		case "registry":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.registry = flag
+		case "accessors":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.accessors = flag
+		case "iterators":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.iterators = flag
+		case "verifiers":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.verifiers = flag
+		case "storage":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.storage = flag
+		case "partial":
+			flag, err := toBoolFlag(key, value)
+			if err != nil {
+				return err
+			}
+			g.partial = flag
+		case "view":
+			err := g.parseViewParameter(value)
+			if err != nil {
+				return err
+			}
 		default:
-			return errors.New("unrecognized option " + key)
+			if !isLimitParameter(key) {
+				return errors.New("unrecognized option " + key)
+			}
+			err := g.parseLimitParameter(key, value)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -136,6 +439,51 @@ func (g *Generator) Generate() (*pluginpb.CodeGeneratorResponse, error) {
 	response := &pluginpb.CodeGeneratorResponse{}
 
 	protoFiles := g.request.GetProtoFile()
+
+	// Register all messages up front, so they can be looked up by name
+	for _, protoFile := range protoFiles {
+		renameWellKnownFields(protoFile)
+		for _, descriptor := range fileMessages(protoFile) {
+			g.messages[descriptor.GetName()] = descriptor
+			g.packages[descriptor.GetName()] = protoFile.GetPackage()
+		}
+	}
+
+	err := g.checkMessageLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkWellKnownBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkViews()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkPartial()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkMerkle()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.checkRegistry()
+	if err != nil {
+		return nil, err
+	}
+
 	for _, protoFile := range protoFiles {
 		responseFile, err := g.generateFile(protoFile)
 		if err != nil {
@@ -143,6 +491,26 @@ func (g *Generator) Generate() (*pluginpb.CodeGeneratorResponse, error) {
 		}
 
 		response.File = append(response.File, responseFile)
+
+		if g.eip712 {
+			schemaFile, err := g.generateEIP712Schema(protoFile)
+			if err != nil {
+				return nil, err
+			}
+
+			response.File = append(response.File, schemaFile)
+		}
+	}
+
+	// Generate support libraries shared by all files
+	if g.isCalldataDecoded() {
+		response.File = append(response.File, g.generateSupportFile(calldataLibName, g.solidityVersionString(), calldataLibSource))
+	}
+	if g.isSupportLibUsed() {
+		response.File = append(response.File, g.generateSupportFile(supportLibName, g.supportLibVersionString(), g.supportLibSource()))
+	}
+	if g.backendFlag == backendFlagTable {
+		response.File = append(response.File, g.generateSupportFile(tableLibName, g.solidityVersionString(), tableLibSource, supportLibName))
 	}
 
 	return response, nil
@@ -156,8 +524,8 @@ func (g *Generator) generateFile(protoFile *descriptorpb.FileDescriptorProto) (*
 		return nil, err
 	}
 
-	// Forbid package declaration
-	if len(protoFile.GetPackage()) > 0 {
+	// Forbid package declaration, except for well-known types
+	if len(protoFile.GetPackage()) > 0 && !isWellKnownFile(protoFile.GetName(), protoFile.GetPackage()) {
 		return nil, errors.New("package declaration forbidden: " + protoFile.GetPackage())
 	}
 
@@ -167,14 +535,24 @@ func (g *Generator) generateFile(protoFile *descriptorpb.FileDescriptorProto) (*
 	// Generate heading
 	b.P(fmt.Sprintf("// File automatically generated by protoc-gen-sol %s", g.versionString))
 	b.P(fmt.Sprintf("// SPDX-License-Identifier: %s", g.licenseString))
-	b.P("pragma solidity " + SolidityVersionString + ";")
+	b.P("pragma solidity " + g.solidityVersionString() + ";")
 	b.P(SolidityABIString)
 	b.P()
 
 	// Generate imports
 	b.P("import \"@lazyledger/protobuf3-solidity-lib/contracts/ProtobufLib.sol\";")
+	if g.isCalldataDecoded() {
+		b.P(fmt.Sprintf("import \"./%s.sol\";", calldataLibName))
+	}
+	if g.isSupportLibUsed() {
+		b.P(fmt.Sprintf("import \"./%s.sol\";", supportLibName))
+	}
+	if g.backendFlag == backendFlagTable {
+		b.P(fmt.Sprintf("import \"./%s.sol\";", tableLibName))
+	}
+	// Generated files are output flat, so imports use base names
 	for _, dependency := range protoFile.GetDependency() {
-		b.P(fmt.Sprintf("import \"./%s.sol\";", dependency))
+		b.P(fmt.Sprintf("import \"./%s.sol\";", filepath.Base(dependency)))
 	}
 	b.P()
 
@@ -187,13 +565,27 @@ func (g *Generator) generateFile(protoFile *descriptorpb.FileDescriptorProto) (*
 	}
 
 	// Generate messages
-	for _, descriptor := range protoFile.GetMessageType() {
+	g.helperLibName = toHelperLibName(protoFile.GetName())
+	for _, descriptor := range fileMessages(protoFile) {
 		err := g.generateMessage(descriptor, b)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// Generate helpers shared by the messages
+	if g.optimizeFlag == optimizeFlagSize {
+		err := g.generateHelperLib(protoFile, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Well-known types are decoded by the registries of the files using them
+	if g.registry && !isWellKnownFile(protoFile.GetName(), protoFile.GetPackage()) {
+		g.generateRegistry(protoFile, b)
+	}
+
 	responseFile := &pluginpb.CodeGeneratorResponse_File{
 		Name:    proto.String(filepath.Base(protoFile.GetName()) + ".sol"),
 		Content: proto.String(b.String()),
@@ -253,10 +645,6 @@ func (g *Generator) generateMessage(descriptor *descriptorpb.DescriptorProto, b
 
 	fields := descriptor.GetField()
 
-	if len(fields) == 0 {
-		return errors.New("messages must have at least one field: " + structName)
-	}
-
 	////////////////////////////////////
 	// Generate struct
 	////////////////////////////////////
@@ -265,8 +653,9 @@ func (g *Generator) generateMessage(descriptor *descriptorpb.DescriptorProto, b
 	b.Indent()
 
 	fieldCount := int32(0)
+	members := make([]string, len(fields))
 	// Loop over fields
-	for _, field := range fields {
+	for i, field := range fields {
 		fieldDescriptorType := field.GetType()
 		fieldName := field.GetName()
 		err = checkKeyword(fieldName)
@@ -309,22 +698,42 @@ func (g *Generator) generateMessage(descriptor *descriptorpb.DescriptorProto, b
 		switch fieldDescriptorType {
 		case descriptorpb.FieldDescriptorProto_TYPE_ENUM,
 			descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			err := checkWellKnownType(field)
+			if err != nil {
+				return errors.New(err.Error() + ": " + structName + "." + fieldName)
+			}
 			fieldTypeName, err := toSolMessageOrEnumName(field)
 			if err != nil {
 				return err
 			}
-			b.P(fmt.Sprintf("%s%s %s;", fieldTypeName, arrayStr, fieldName))
+			members[i] = fmt.Sprintf("%s%s %s;", fieldTypeName, arrayStr, fieldName)
 		default:
 			// Convert protobuf field type to Solidity native type
 			fieldType, err := typeToSol(fieldDescriptorType)
 			if err != nil {
 				return errors.New(err.Error() + ": " + structName + "." + fieldName)
 			}
+			if g.isFieldView(structName, field) {
+				fieldType = viewTypeName
+			}
 
-			b.P(fmt.Sprintf("%s%s %s;", fieldType, arrayStr, fieldName))
+			members[i] = fmt.Sprintf("%s%s %s;", fieldType, arrayStr, fieldName)
 		}
 	}
 
+	// Members are declared in layout order, which may differ from field order
+	for _, i := range g.memberOrder(fields) {
+		b.P(members[i])
+	}
+	if len(fields) == 0 {
+		b.P("// Placeholder, since Solidity forbids empty structs. Not encoded.")
+		b.P("bool " + emptyPlaceholderName + ";")
+	}
+	if g.isWrapper(structName) {
+		b.P("// Set if the field holding the wrapper is present, even with the default value")
+		b.P("bool present;")
+	}
+
 	b.Unindent()
 	b.P("}")
 	b.P()
@@ -332,15 +741,58 @@ func (g *Generator) generateMessage(descriptor *descriptorpb.DescriptorProto, b
 	b.P(fmt.Sprintf("library %sCodec {", structName))
 	b.Indent()
 
+	err = g.generateSchemaConstants(structName, b)
+	if err != nil {
+		return err
+	}
+
+	switch g.backendFlag {
+	case backendFlagTable:
+		err = g.generateTableCodec(structName, b)
+	case backendFlagYul:
+		err = g.generateYulCodec(structName, b)
+	default:
+		err = g.generateSolidityCodec(structName, fields, b)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The functions below only rely on the struct and on the decode, encode,
+	// cached_sizes and encode_at functions of codecs, so they are shared by all
+	// backends
 	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
-		err = g.generateMessageDecoder(structName, fields, b)
+		for _, input := range g.decoderInputs() {
+			generateStreamDecoder(structName, input, b)
+		}
+	}
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		generateEncodeTo(structName, b)
+		generateStreamEncoder(structName, b)
+	}
+
+	if g.hashFlag != hashFlagNone {
+		g.generateHashFunctions(structName, b)
+	}
+
+	if g.merkle {
+		err = g.generateMerkleFunctions(structName, fields, b)
 		if err != nil {
 			return err
 		}
 	}
 
-	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
-		err = g.generateMessageEncoder(structName, fields, b)
+	if g.eip712 {
+		err = g.generateEIP712Functions(structName, fields, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	g.generateWellKnownFunctions(structName, b)
+
+	if g.storage {
+		err = g.generateStorageFunctions(structName, fields, b)
 		if err != nil {
 			return err
 		}
@@ -353,69 +805,148 @@ func (g *Generator) generateMessage(descriptor *descriptorpb.DescriptorProto, b
 	return nil
 }
 
-// Generate decoder
-func (g *Generator) generateMessageDecoder(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
-	// Top-level decoder function
-	b.P(fmt.Sprintf("function decode(uint64 initial_pos, bytes memory buf, uint64 len) internal pure returns (bool, uint64, %s memory) {", structName))
-	b.Indent()
+// generateSolidityCodec generates the functions of a message's codec library
+// as unrolled Solidity code.
+func (g *Generator) generateSolidityCodec(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
+		for _, input := range g.decoderInputs() {
+			err := g.generateMessageDecoder(structName, fields, input, b)
+			if err != nil {
+				return err
+			}
+		}
 
-	b.P("// Message instance")
-	b.P(fmt.Sprintf("%s memory instance;", structName))
-	b.P("// Previous field number")
-	b.P("uint64 previous_field_number = 0;")
-	b.P("// Current position in the buffer")
-	b.P("uint64 pos = initial_pos;")
-	b.P()
+		err := g.generateCheckKey(fields, b)
+		if err != nil {
+			return err
+		}
 
-	b.P("// Sanity checks")
-	b.P("if (pos + len < pos) {")
-	b.Indent()
-	b.P("return (false, pos, instance);")
-	b.Unindent()
-	b.P("}")
-	b.P()
+		if g.partial {
+			err := g.generatePartialDecoder(structName, fields, b)
+			if err != nil {
+				return err
+			}
+		}
 
-	b.P("while (pos - initial_pos < len) {")
-	b.Indent()
-	b.P("// Decode the key (field number and wire type)")
-	b.P("bool success;")
-	b.P("uint64 field_number;")
-	b.P("ProtobufLib.WireType wire_type;")
-	b.P("(success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);")
-	b.P("if (!success) {")
+		// Accessors, verifiers and iterators share the search for a field
+		if g.accessors || g.verifiers || g.iterators {
+			g.generateFindField(fields, b)
+		}
+
+		if g.accessors {
+			err := g.generateMessageAccessors(structName, fields, b)
+			if err != nil {
+				return err
+			}
+		}
+
+		if g.verifiers {
+			err := g.generateVerifiers(structName, fields, b)
+			if err != nil {
+				return err
+			}
+		}
+
+		if g.iterators {
+			err := g.generateIterators(structName, fields, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		err := g.generateMessageEncoder(structName, fields, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// emptyPlaceholderName is the name of the member of the structs of messages
+// without fields, which is never encoded.
+const emptyPlaceholderName = "_empty"
+
+// Generate decoder
+func (g *Generator) generateMessageDecoder(structName string, fields []*descriptorpb.FieldDescriptorProto, input decoderInput, b *WriteableBuffer) error {
+	limits := g.limitsFor(structName)
+
+	// Nesting depth is only threaded through decoders if a limit requires it
+	depthParam := ""
+	depthArg := ""
+	if g.isDepthTracked() {
+		depthParam = ", uint64 depth"
+		depthArg = ", depth"
+
+		b.P(fmt.Sprintf("function decode%s(uint64 initial_pos, %s buf, uint64 len) internal pure returns (bool, uint64, %s memory) {", input.suffix, input.bufType, structName))
+		b.Indent()
+		b.P(fmt.Sprintf("return decode%s(initial_pos, buf, len, 0);", input.suffix))
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+
+	// Top-level decoder function
+	b.P(fmt.Sprintf("function decode%s(uint64 initial_pos, %s buf, uint64 len%s) internal pure returns (bool, uint64, %s memory) {", input.suffix, input.bufType, depthParam, structName))
+	b.Indent()
+
+	b.P("// Message instance")
+	b.P(fmt.Sprintf("%s memory instance;", structName))
+	b.P("// Previous field number")
+	b.P("uint64 previous_field_number = 0;")
+	b.P("// Current position in the buffer")
+	b.P("uint64 pos = initial_pos;")
+	b.P()
+
+	b.P("// Sanity checks")
+	b.P("if (pos + len < pos) {")
 	b.Indent()
 	b.P("return (false, pos, instance);")
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	b.P("// Check that the field number is within bounds")
-	b.P(fmt.Sprintf("if (field_number > %d) {", len(fields)))
+	if limits.maxSize > 0 {
+		generateLimitCheck(fmt.Sprintf("len > %d", limits.maxSize), structName+": max_size exceeded", b)
+	}
+	if limits.maxDepth > 0 {
+		generateLimitCheck(fmt.Sprintf("depth > %d", limits.maxDepth), structName+": max_depth exceeded", b)
+	}
+
+	b.P("while (pos - initial_pos < len) {")
+	b.Indent()
+	b.P("// Decode the key (field number and wire type)")
+	b.P("bool success;")
+	b.P("uint64 field_number;")
+	b.P("ProtobufLib.WireType wire_type;")
+	b.P(fmt.Sprintf("(success, pos, field_number, wire_type) = %s.decode_key(pos, buf);", input.lib))
+	b.P("if (!success) {")
 	b.Indent()
 	b.P("return (false, pos, instance);")
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	b.P("// Check that the field number of monotonically increasing")
-	b.P("if (field_number <= previous_field_number) {")
+	b.P("// Check that the field number is within bounds")
+	b.P(fmt.Sprintf("if (field_number > %d) {", len(fields)))
 	b.Indent()
 	b.P("return (false, pos, instance);")
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	b.P("// Check that the wire type is correct")
-	b.P("success = check_key(field_number, wire_type);")
-	b.P("if (!success) {")
+	b.P("// Check that the field number of monotonically increasing")
+	b.P("if (field_number <= previous_field_number) {")
 	b.Indent()
 	b.P("return (false, pos, instance);")
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	b.P("// Actually decode the field")
-	b.P("(success, pos) = decode_field(pos, buf, len, field_number, instance);")
+	b.P("// Actually decode the field, checking that the wire type is correct")
+	b.P(fmt.Sprintf("(success, pos) = decode_field%s(pos, buf, initial_pos + len, field_number, wire_type, instance%s);", input.suffix, depthArg))
 	b.P("if (!success) {")
 	b.Indent()
 	b.P("return (false, pos, instance);")
@@ -436,61 +967,37 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 	b.P("}")
 	b.P()
 
-	b.P("return (true, pos, instance);")
-	b.Unindent()
-	b.P("}")
-	b.P()
-
-	// Check key function
-	b.P("function check_key(uint64 field_number, ProtobufLib.WireType wire_type) internal pure returns (bool) {")
-	b.Indent()
-	for _, field := range fields {
-		fieldNumber := field.GetNumber()
-
-		b.P(fmt.Sprintf("if (field_number == %d) {", fieldNumber))
+	if g.isValidated(structName) {
+		b.P("if (!is_valid(instance)) {")
 		b.Indent()
-		wireStr, err := toSolWireType(field)
-		if err != nil {
-			return err
-		}
-		b.P(fmt.Sprintf("return wire_type == %s;", wireStr))
+		b.P("return (false, pos, instance);")
 		b.Unindent()
 		b.P("}")
 		b.P()
 	}
+	if g.isWrapper(structName) {
+		b.P("// Decoded wrappers are present")
+		b.P("instance.present = true;")
+		b.P()
+	}
 
-	b.P("return false;")
+	b.P("return (true, pos, instance);")
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	// Decode field dispatcher function
-	b.P(fmt.Sprintf("function decode_field(uint64 initial_pos, bytes memory buf, uint64 len, uint64 field_number, %s memory instance) internal pure returns (bool, uint64) {", structName))
+	// Decode field dispatcher function, with a binary search over field numbers
+	b.P("// Decode a field, whose number must be within bounds")
+	b.P(fmt.Sprintf("function decode_field%s(uint64 initial_pos, %s buf, uint64 end, uint64 field_number, ProtobufLib.WireType wire_type, %s memory instance%s) internal pure returns (bool, uint64) {", input.suffix, input.bufType, structName, depthParam))
 	b.Indent()
 	b.P("uint64 pos = initial_pos;")
+	b.P("bool success;")
 	b.P()
 
-	for _, field := range fields {
-		fieldNumber := field.GetNumber()
-
-		b.P(fmt.Sprintf("if (field_number == %d) {", fieldNumber))
-		b.Indent()
-		b.P("bool success;")
-		b.P(fmt.Sprintf("(success, pos) = decode_%d(pos, buf, instance);", fieldNumber))
-		b.P("if (!success) {")
-		b.Indent()
-		b.P("return (false, pos);")
-		b.Unindent()
-		b.P("}")
-		b.P()
-
-		b.P("return (true, pos);")
-		b.Unindent()
-		b.P("}")
-		b.P()
+	err := generateFieldDispatch(fields, input, depthArg, b)
+	if err != nil {
+		return err
 	}
-
-	b.P("return (false, pos);")
 	b.Unindent()
 	b.P("}")
 	b.P()
@@ -501,14 +1008,31 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 		fieldDescriptorType := field.GetType()
 		fieldNumber := field.GetNumber()
 
+		fieldDepthParam := ""
+		nestedDepthArg := ""
+		if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && len(depthParam) > 0 {
+			fieldDepthParam = depthParam
+			nestedDepthArg = ", depth + 1"
+		}
+		fieldEndParam := ""
+		if isEndPassed(field) {
+			fieldEndParam = ", uint64 end"
+		}
+
 		b.P(fmt.Sprintf("// %s.%s", structName, fieldName))
-		b.P(fmt.Sprintf("function decode_%d(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (bool, uint64) {", fieldNumber, structName))
+		b.P(fmt.Sprintf("function decode_%d%s(uint64 pos, %s buf%s, %s memory instance%s) internal pure returns (bool, uint64) {", fieldNumber, input.suffix, input.bufType, fieldEndParam, structName, fieldDepthParam))
 		b.Indent()
 
 		b.P("bool success;")
 		b.P()
 
-		if isFieldRepeated(field) {
+		if g.isHelperUsed(field) {
+			// Field decoded by a helper function
+			err := g.generateHelperDecode(structName, field, input, limits, b)
+			if err != nil {
+				return err
+			}
+		} else if isFieldRepeated(field) {
 			// Repeated field
 
 			if isFieldPacked(field) {
@@ -524,7 +1048,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					}
 
 					b.P("uint64 len;")
-					b.P(fmt.Sprintf("(success, pos, len) = ProtobufLib.decode_length_delimited(pos, buf);"))
+					b.P(fmt.Sprintf("(success, pos, len) = %s.decode_length_delimited(pos, buf);", input.lib))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -551,32 +1075,21 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					b.P("}")
 					b.P()
 
-					b.P("// Do one pass to count the number of elements")
-					b.P("uint64 cnt = 0;")
-					b.P("while (pos - initial_pos < len) {")
-					b.Indent()
-					b.P("int32 v;")
-					b.P("(success, pos, v) = ProtobufLib.decode_enum(pos, buf);")
-					b.P("if (!success) {")
-					b.Indent()
-					b.P("return (false, pos);")
-					b.Unindent()
-					b.P("}")
-					b.P("cnt += 1;")
-					b.Unindent()
-					b.P("}")
-					b.P()
+					generatePackedCount(fieldDescriptorType, "(false, pos)", b)
+
+					if limits.maxRepeated > 0 {
+						generateLimitCheck(fmt.Sprintf("cnt > %d", limits.maxRepeated), structName+"."+fieldName+": max_repeated exceeded", b)
+					}
 
 					b.P("// Allocated memory")
 					b.P(fmt.Sprintf("instance.%s = new %s[](cnt);", fieldName, fieldTypeName))
 					b.P()
 
-					b.P("// Now actually parse the elements")
-					b.P("pos = initial_pos;")
+					b.P("// Parse the elements")
 					b.P("for (uint64 i = 0; i < cnt; i++) {")
 					b.Indent()
 					b.P("int32 v;")
-					b.P("(success, pos, v) = ProtobufLib.decode_enum(pos, buf);")
+					b.P(fmt.Sprintf("(success, pos, v) = %s.decode_enum(pos, buf);", input.lib))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -617,7 +1130,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					}
 
 					b.P("uint64 len;")
-					b.P(fmt.Sprintf("(success, pos, len) = ProtobufLib.decode_length_delimited(pos, buf);"))
+					b.P(fmt.Sprintf("(success, pos, len) = %s.decode_length_delimited(pos, buf);", input.lib))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -644,32 +1157,21 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					b.P("}")
 					b.P()
 
-					b.P("// Do one pass to count the number of elements")
-					b.P("uint64 cnt = 0;")
-					b.P("while (pos - initial_pos < len) {")
-					b.Indent()
-					b.P(fmt.Sprintf("%s v;", fieldType))
-					b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
-					b.P("if (!success) {")
-					b.Indent()
-					b.P("return (false, pos);")
-					b.Unindent()
-					b.P("}")
-					b.P("cnt += 1;")
-					b.Unindent()
-					b.P("}")
-					b.P()
+					generatePackedCount(fieldDescriptorType, "(false, pos)", b)
+
+					if limits.maxRepeated > 0 {
+						generateLimitCheck(fmt.Sprintf("cnt > %d", limits.maxRepeated), structName+"."+fieldName+": max_repeated exceeded", b)
+					}
 
 					b.P("// Allocated memory")
 					b.P(fmt.Sprintf("instance.%s = new %s[](cnt);", fieldName, fieldType))
 					b.P()
 
-					b.P("// Now actually parse the elements")
-					b.P("pos = initial_pos;")
+					b.P("// Parse the elements")
 					b.P("for (uint64 i = 0; i < cnt; i++) {")
 					b.Indent()
 					b.P(fmt.Sprintf("%s v;", fieldType))
-					b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
+					b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s(pos, buf);", input.lib, fieldDecodeType))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -701,12 +1203,12 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("uint64 initial_pos = pos;")
 				b.P()
 
-				b.P("// Do one pass to count the number of elements")
+				b.P("// Do one pass to count the number of elements, up to the end of the message")
 				b.P("uint64 cnt = 0;")
-				b.P("while (pos < buf.length) {")
+				b.P("while (pos < end) {")
 				b.Indent()
 				b.P("uint64 len;")
-				b.P("(success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, len) = %s.decode_embedded_message(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -726,7 +1228,11 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("cnt += 1;")
 				b.P()
 
-				b.P("if (pos >= buf.length) {")
+				if limits.maxRepeated > 0 {
+					generateLimitCheck(fmt.Sprintf("cnt > %d", limits.maxRepeated), structName+"."+fieldName+": max_repeated exceeded", b)
+				}
+
+				b.P("if (pos >= end) {")
 				b.Indent()
 				b.P("break;")
 				b.Unindent()
@@ -736,7 +1242,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("// Decode next key")
 				b.P("uint64 field_number;")
 				b.P("ProtobufLib.WireType wire_type;")
-				b.P("(success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, field_number, wire_type) = %s.decode_key(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -763,7 +1269,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("for (uint64 i = 0; i < cnt; i++) {")
 				b.Indent()
 				b.P("uint64 len;")
-				b.P("(success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, len) = %s.decode_embedded_message(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -775,7 +1281,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P()
 
 				b.P(fmt.Sprintf("%s memory nestedInstance;", fieldTypeName))
-				b.P(fmt.Sprintf("(success, pos, nestedInstance) = %sCodec.decode(pos, buf, len);", fieldTypeName))
+				b.P(fmt.Sprintf("(success, pos, nestedInstance) = %sCodec.decode%s(pos, buf, len%s);", fieldTypeName, input.suffix, nestedDepthArg))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -789,7 +1295,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("// Skip over next key, reuse len")
 				b.P("if (i < cnt - 1) {")
 				b.Indent()
-				b.P("(success, pos, len) = ProtobufLib.decode_uint64(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, len) = %s.decode_uint64(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -812,7 +1318,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				}
 
 				b.P("int32 v;")
-				b.P("(success, pos, v) = ProtobufLib.decode_enum(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, v) = %s.decode_enum(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -846,7 +1352,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				}
 
 				b.P("uint64 len;")
-				b.P("(success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);")
+				b.P(fmt.Sprintf("(success, pos, len) = %s.decode_embedded_message(pos, buf);", input.lib))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -854,16 +1360,19 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 				b.P("}")
 				b.P()
 
-				b.P("// Default value must be omitted")
-				b.P("if (len == 0) {")
-				b.Indent()
-				b.P("return (false, pos);")
-				b.Unindent()
-				b.P("}")
-				b.P()
+				// Wrappers carry presence, so may hold the default value
+				if !g.isFieldWrapper(field) {
+					b.P("// Default value must be omitted")
+					b.P("if (len == 0) {")
+					b.Indent()
+					b.P("return (false, pos);")
+					b.Unindent()
+					b.P("}")
+					b.P()
+				}
 
 				b.P(fmt.Sprintf("%s memory nestedInstance;", fieldTypeName))
-				b.P(fmt.Sprintf("(success, pos, nestedInstance) = %sCodec.decode(pos, buf, len);", fieldTypeName))
+				b.P(fmt.Sprintf("(success, pos, nestedInstance) = %sCodec.decode%s(pos, buf, len%s);", fieldTypeName, input.suffix, nestedDepthArg))
 				b.P("if (!success) {")
 				b.Indent()
 				b.P("return (false, pos);")
@@ -895,7 +1404,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
 					descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
 					b.P(fmt.Sprintf("%s v;", fieldType))
-					b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
+					b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s(pos, buf);", input.lib, fieldDecodeType))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -915,7 +1424,7 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					b.P()
 				case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
 					b.P(fmt.Sprintf("%s v;", fieldType))
-					b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
+					b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s(pos, buf);", input.lib, fieldDecodeType))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -933,29 +1442,10 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 
 					b.P(fmt.Sprintf("instance.%s = v;", fieldName))
 					b.P()
-				case descriptorpb.FieldDescriptorProto_TYPE_STRING:
-					b.P(fmt.Sprintf("%s memory v;", fieldType))
-					b.P(fmt.Sprintf("(success, pos, v) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
-					b.P("if (!success) {")
-					b.Indent()
-					b.P("return (false, pos);")
-					b.Unindent()
-					b.P("}")
-					b.P()
-
-					b.P("// Default value must be omitted")
-					b.P("if (bytes(v).length == 0) {")
-					b.Indent()
-					b.P("return (false, pos);")
-					b.Unindent()
-					b.P("}")
-					b.P()
-
-					b.P(fmt.Sprintf("instance.%s = v;", fieldName))
-					b.P()
-				case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+				case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+					descriptorpb.FieldDescriptorProto_TYPE_BYTES:
 					b.P("uint64 len;")
-					b.P(fmt.Sprintf("(success, pos, len) = ProtobufLib.decode_%s(pos, buf);", fieldDecodeType))
+					b.P(fmt.Sprintf("(success, pos, len) = %s.decode_length_delimited(pos, buf);", input.lib))
 					b.P("if (!success) {")
 					b.Indent()
 					b.P("return (false, pos);")
@@ -971,12 +1461,27 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 					b.P("}")
 					b.P()
 
-					b.P(fmt.Sprintf("instance.%s = new bytes(len);", fieldName))
-					b.P("for (uint64 i = 0; i < len; i++) {")
-					b.Indent()
-					b.P(fmt.Sprintf("instance.%s[i] = buf[pos + i];", fieldName))
-					b.Unindent()
-					b.P("}")
+					if limits.maxLength > 0 {
+						generateLimitCheck(fmt.Sprintf("len > %d", limits.maxLength), structName+"."+fieldName+": max_length exceeded", b)
+					}
+
+					switch input {
+					case calldataInput:
+						if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_STRING {
+							b.P("bytes memory v = buf[pos:pos + len];")
+							b.P(fmt.Sprintf("instance.%s = string(v);", fieldName))
+						} else {
+							b.P(fmt.Sprintf("instance.%s = buf[pos:pos + len];", fieldName))
+						}
+					default:
+						if g.isFieldView(structName, field) {
+							b.P(fmt.Sprintf("instance.%s = %s(buf, pos, len);", fieldName, viewTypeName))
+						} else if fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_STRING {
+							b.P(fmt.Sprintf("instance.%s = string(ProtobufSupportLib.slice(buf, pos, len));", fieldName))
+						} else {
+							b.P(fmt.Sprintf("instance.%s = ProtobufSupportLib.slice(buf, pos, len);", fieldName))
+						}
+					}
 					b.P()
 
 					b.P("pos = pos + len;")
@@ -996,360 +1501,192 @@ func (g *Generator) generateMessageDecoder(structName string, fields []*descript
 	return nil
 }
 
-// Generate encoder
-func (g *Generator) generateMessageEncoder(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
-	structNameEncoded := structName + "__Encoded"
-	structNameEncodedNested := structNameEncoded + "__Nested"
-
-	////////////////////////////////////
-	// Generate structs to hold encoded version of message struct
-	////////////////////////////////////
-
-	b.P("// Holds encoded version of message")
-	b.P(fmt.Sprintf("struct %s {", structNameEncoded))
-	b.Indent()
-
-	// Loop over fields
+// Generate check key function, shared by decoders for all inputs
+func (g *Generator) generateCheckKey(fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	// Wire types are packed 4 bits per field, indexed by field number
+	words := make([]*big.Int, len(fields)/wireTypesPerWord+1)
+	for i := range words {
+		words[i] = new(big.Int)
+	}
 	for _, field := range fields {
-		fieldDescriptorType := field.GetType()
-		fieldName := field.GetName()
-		err := checkKeyword(fieldName)
+		wireType, err := toWireType(field)
 		if err != nil {
 			return err
 		}
 
-		switch fieldDescriptorType {
-		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
-			fieldTypeName, err := toSolMessageOrEnumName(field)
-			if err != nil {
-				return err
-			}
-			fieldTypeNameEncodedNested := fieldTypeName + "__Encoded__Nested"
+		fieldNumber := int(field.GetNumber())
+		word := words[fieldNumber/wireTypesPerWord]
+		word.Or(word, new(big.Int).Lsh(new(big.Int).SetUint64(wireType), uint(fieldNumber%wireTypesPerWord*4)))
+	}
 
-			arrayStr := ""
-			if isFieldRepeated(field) {
-				arrayStr = "[]"
-			}
+	b.P("// Wire type of each field, 4 bits per field indexed by field number")
+	for i, word := range words {
+		b.P(fmt.Sprintf("uint256 constant WIRE_TYPES_%d = 0x%064x;", i, word))
+	}
+	b.P()
 
-			b.P(fmt.Sprintf("%sCodec.%s%s %s;", fieldTypeName, fieldTypeNameEncodedNested, arrayStr, fieldName))
+	b.P("function check_key(uint64 field_number, ProtobufLib.WireType wire_type) internal pure returns (bool) {")
+	b.Indent()
+	b.P(fmt.Sprintf("if (field_number == 0 || field_number > %d) {", len(fields)))
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
 
-			// Add a field for encoded version of nested message
-			b.P(fmt.Sprintf("bytes %s__Encoded;", fieldName))
-		default:
-			// Add a field for the key
-			fieldNameKey := fieldName + "__Key"
-			b.P(fmt.Sprintf("bytes %s;", fieldNameKey))
-
-			// For repeated and length-delimited fields, add field for length in bytes
-			if isFieldRepeated(field) ||
-				fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_STRING ||
-				fieldDescriptorType == descriptorpb.FieldDescriptorProto_TYPE_BYTES {
-				fieldNameLength := fieldName + "__Length"
-				b.P(fmt.Sprintf("bytes %s;", fieldNameLength))
+	if len(words) == 1 {
+		b.P("uint256 wire_types = WIRE_TYPES_0;")
+	} else {
+		b.P("uint256 wire_types;")
+		for i := range words {
+			switch i {
+			case 0:
+				b.P(fmt.Sprintf("if (field_number < %d) {", wireTypesPerWord))
+			case len(words) - 1:
+				b.P("} else {")
+			default:
+				b.P(fmt.Sprintf("} else if (field_number < %d) {", (i+1)*wireTypesPerWord))
 			}
-
-			b.P(fmt.Sprintf("bytes %s;", fieldName))
+			b.Indent()
+			b.P(fmt.Sprintf("wire_types = WIRE_TYPES_%d;", i))
+			b.Unindent()
 		}
+		b.P("}")
 	}
+	b.P()
 
+	b.P(fmt.Sprintf("return ((wire_types >> ((field_number %% %d) * 4)) & 0xF) == uint256(wire_type);", wireTypesPerWord))
 	b.Unindent()
 	b.P("}")
 	b.P()
 
-	b.P("// Holds encoded version of nested message")
-	b.P(fmt.Sprintf("struct %s {", structNameEncodedNested))
-	b.Indent()
-
-	b.P("bytes key;")
-	b.P("bytes length;")
-	b.P("bytes nestedInstance;")
+	return nil
+}
 
-	b.Unindent()
-	b.P("}")
-	b.P()
+// isEndPassed returns true if the decoder of a field is passed the end of the
+// message being decoded. Only repeated embedded messages need it, to count their
+// elements without reading past the message.
+func isEndPassed(field *descriptorpb.FieldDescriptorProto) bool {
+	return isFieldRepeated(field) && field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+}
 
-	////////////////////////////////////
-	// Generate encoder for non-nested message
-	////////////////////////////////////
+// wireTypesPerWord is the number of 4-bit wire types packed in a uint256.
+const wireTypesPerWord = 64
 
-	b.P(fmt.Sprintf("function encode(%s memory instance) internal pure returns (bytes memory) {", structName))
-	b.Indent()
+// generateFieldDispatch generates a binary search over fields that checks the
+// wire type of the field with number field_number and decodes it.
+func generateFieldDispatch(fields []*descriptorpb.FieldDescriptorProto, input decoderInput, depthArg string, b *WriteableBuffer) error {
+	if len(fields) == 0 {
+		b.P("return (false, pos);")
+		return nil
+	}
 
-	b.P(fmt.Sprintf("%s memory encodedInstance;", structNameEncoded))
-	b.P("uint64 len;")
-	b.P("uint64 index;")
-	b.P()
+	if len(fields) > 1 {
+		mid := len(fields) / 2
 
-	// Loop over fields
-	for _, field := range fields {
-		fieldDescriptorType := field.GetType()
-		fieldName := field.GetName()
-		err := checkKeyword(fieldName)
+		b.P(fmt.Sprintf("if (field_number < %d) {", fields[mid].GetNumber()))
+		b.Indent()
+		err := generateFieldDispatch(fields[:mid], input, depthArg, b)
 		if err != nil {
 			return err
 		}
-
-		switch fieldDescriptorType {
-		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
-			// Message type
-
-			fieldNumber := field.GetNumber()
-			fieldTypeName, err := toSolMessageOrEnumName(field)
-			if err != nil {
-				return err
-			}
-
-			if isFieldRepeated(field) {
-				// Repeated message
-
-				fieldTypeNameEncodedNested := fieldTypeName + "__Encoded__Nested"
-
-				b.P(fmt.Sprintf("// Encode %s", fieldName))
-				b.P("len = 0;")
-				b.P(fmt.Sprintf("encodedInstance.%s = new %sCodec.%s[](instance.%s.length);", fieldName, fieldTypeName, fieldTypeNameEncodedNested, fieldName))
-				b.P(fmt.Sprintf("for (uint64 i = 0; i < instance.%s.length; i++) {", fieldName))
-				b.Indent()
-				b.P(fmt.Sprintf("encodedInstance.%s[i] = %sCodec.encodeNested(%d, instance.%s[i]);", fieldName, fieldTypeName, fieldNumber, fieldName))
-				b.P(fmt.Sprintf("len += uint64(encodedInstance.%s[i].key.length + encodedInstance.%s[i].length.length + encodedInstance.%s[i].nestedInstance.length);", fieldName, fieldName, fieldName))
-				b.Unindent()
-				b.P("}")
-				b.P()
-
-				b.P(fmt.Sprintf("encodedInstance.%s__Encoded = new bytes(len);", fieldName))
-				b.P("index = 0;")
-				b.P(fmt.Sprintf("for (uint64 i = 0; i < instance.%s.length; i++) {", fieldName))
-				b.Indent()
-				b.P("uint64 j = 0;")
-				b.P(fmt.Sprintf("while (j < encodedInstance.%s[i].key.length) {", fieldName))
-				b.Indent()
-				b.P(fmt.Sprintf("encodedInstance.%s__Encoded[index++] = encodedInstance.%s[i].key[j++];", fieldName, fieldName))
-				b.Unindent()
-				b.P("}")
-				b.P("j = 0;")
-				b.P(fmt.Sprintf("while (j < encodedInstance.%s[i].length.length) {", fieldName))
-				b.Indent()
-				b.P(fmt.Sprintf("encodedInstance.%s__Encoded[index++] = encodedInstance.%s[i].length[j++];", fieldName, fieldName))
-				b.Unindent()
-				b.P("}")
-				b.P("j = 0;")
-				b.P(fmt.Sprintf("while (j < encodedInstance.%s[i].nestedInstance.length) {", fieldName))
-				b.Indent()
-				b.P(fmt.Sprintf("encodedInstance.%s__Encoded[index++] = encodedInstance.%s[i].nestedInstance[j++];", fieldName, fieldName))
-				b.Unindent()
-				b.P("}")
-				b.Unindent()
-				b.P("}")
-				b.P()
-			} else {
-				// Non-repeated message
-
-				b.P(fmt.Sprintf("// Encode %s", fieldName))
-				b.P(fmt.Sprintf("encodedInstance.%s = %sCodec.encodeNested(%d, instance.%s);", fieldName, fieldTypeName, fieldNumber, fieldName))
-				b.P(fmt.Sprintf("encodedInstance.%s__Encoded = abi.encodePacked(encodedInstance.%s.key, encodedInstance.%s.length, encodedInstance.%s.nestedInstance);", fieldName, fieldName, fieldName, fieldName))
-				b.P()
-			}
-		default:
-			// Non-message type
-
-			fieldNumber := field.GetNumber()
-			fieldNameKey := fieldName + "__Key"
-			fieldNameLength := fieldName + "__Length"
-
-			if isFieldRepeated(field) {
-				// Repeated numeric type
-
-				fieldNameLength := fieldName + "__Length"
-
-				b.P(fmt.Sprintf("// Encode %s if length non-zero", fieldName))
-				b.P(fmt.Sprintf("len = uint64(instance.%s.length);", fieldName))
-				b.P("if (len > 0) {")
-				b.Indent()
-
-				b.P("// Encode key")
-				wireStr, err := toSolWireType(field)
-				if err != nil {
-					return err
-				}
-				b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_key(%d, uint64(%s));", fieldNameKey, fieldNumber, wireStr))
-				b.P()
-
-				b.P("// Encode length")
-				b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_uint64(len);", fieldNameLength))
-				b.P()
-
-				b.P("// Allocate enough bytes for len up-to-10-byte varints")
-				b.P("bytes memory temp = new bytes(len * 10);")
-				b.P("uint64 tempLength = 0;")
-				b.P("for (uint64 i = 0; i < len; i++) {")
-				b.Indent()
-
-				b.P("// Encode each element in the array and append it to temp")
-				switch fieldDescriptorType {
-				case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
-					b.P(fmt.Sprintf("bytes memory tempElement = ProtobufLib.encode_int32(int32(instance.%s[i]));", fieldName))
-				default:
-					fieldDecodeType, err := typeToDecodeSol(fieldDescriptorType)
-					if err != nil {
-						return errors.New(err.Error() + ": " + structName + "." + fieldName)
-					}
-					b.P(fmt.Sprintf("bytes memory tempElement = ProtobufLib.encode_%s(instance.%s[i]);", fieldDecodeType, fieldName))
-				}
-				b.P("for (uint64 j = 0; j < tempElement.length; j++) {")
-				b.Indent()
-				b.P("temp[tempLength++] = tempElement[j];")
-				b.Unindent()
-				b.P("}")
-
-				b.Unindent()
-				b.P("}")
-				b.P()
-
-				b.P("// Allocate just enough bytes and copy temp bytes over")
-				b.P("bytes memory encodedBytes = new bytes(tempLength);")
-				b.P("for (uint64 i = 0; i < temp.length; i++) {")
-				b.Indent()
-				b.P("encodedBytes[i] = temp[i];")
-				b.Unindent()
-				b.P("}")
-				b.P()
-
-				b.P(fmt.Sprintf("encodedInstance.%s = encodedBytes;", fieldName))
-				b.Unindent()
-				b.P("}")
-				b.P()
-			} else {
-				// Non-repeated non-message (numeric, or string/bytes)
-
-				b.P(fmt.Sprintf("// Omit encoding %s if default value", fieldName))
-				switch fieldDescriptorType {
-				case descriptorpb.FieldDescriptorProto_TYPE_STRING,
-					descriptorpb.FieldDescriptorProto_TYPE_BYTES:
-					b.P(fmt.Sprintf("if (bytes(instance.%s).length > 0) {", fieldName))
-				case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
-					b.P(fmt.Sprintf("if (bool(instance.%s) != false) {", fieldName))
-				default:
-					b.P(fmt.Sprintf("if (uint64(instance.%s) != 0) {", fieldName))
-				}
-				b.Indent()
-
-				b.P(fmt.Sprintf("// Encode key for %s", fieldName))
-				wireStr, err := toSolWireType(field)
-				if err != nil {
-					return err
-				}
-				b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_key(%d, uint64(%s));", fieldNameKey, fieldNumber, wireStr))
-
-				b.P(fmt.Sprintf("// Encode %s", fieldName))
-				switch fieldDescriptorType {
-				case descriptorpb.FieldDescriptorProto_TYPE_STRING,
-					descriptorpb.FieldDescriptorProto_TYPE_BYTES:
-					b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_uint64(uint64(bytes(instance.%s).length));", fieldNameLength, fieldName))
-					b.P(fmt.Sprintf("encodedInstance.%s = bytes(instance.%s);", fieldName, fieldName))
-				case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
-					b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_int32(int32(instance.%s));", fieldName, fieldName))
-				default:
-					fieldDecodeType, err := typeToDecodeSol(fieldDescriptorType)
-					if err != nil {
-						return errors.New(err.Error() + ": " + structName + "." + fieldName)
-					}
-					b.P(fmt.Sprintf("encodedInstance.%s = ProtobufLib.encode_%s(instance.%s);", fieldName, fieldDecodeType, fieldName))
-				}
-
-				b.Unindent()
-				b.P("}")
-				b.P()
-			}
-		}
-	}
-
-	// We can't use abi.encodePacked here because of EVM stack limits
-	b.P("bytes memory finalEncoded;")
-	b.P("index = 0;")
-	b.P("len = 0;")
-	for _, field := range fields {
-		fieldDescriptorType := field.GetType()
-		fieldName := field.GetName()
-		err := checkKeyword(fieldName)
+		b.Unindent()
+		b.P("} else {")
+		b.Indent()
+		err = generateFieldDispatch(fields[mid:], input, depthArg, b)
 		if err != nil {
 			return err
 		}
-
-		switch fieldDescriptorType {
-		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
-			// Message type
-			b.P(fmt.Sprintf("len += uint64(encodedInstance.%s__Encoded.length);", fieldName))
-		default:
-			// Non-message type
-			b.P(fmt.Sprintf("len += uint64(encodedInstance.%s.length);", fieldName))
-		}
+		b.Unindent()
+		b.P("}")
+		return nil
 	}
-	b.P("finalEncoded = new bytes(len);")
-	b.P()
 
-	b.P("uint64 j;")
-	for _, field := range fields {
-		fieldDescriptorType := field.GetType()
-		fieldName := field.GetName()
-		err := checkKeyword(fieldName)
-		if err != nil {
-			return err
-		}
+	field := fields[0]
+	fieldNumber := field.GetNumber()
 
-		switch fieldDescriptorType {
-		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
-			// Message type
-			b.P("j = 0;")
-			b.P(fmt.Sprintf("while (j < encodedInstance.%s__Encoded.length) {", fieldName))
-			b.Indent()
-			b.P(fmt.Sprintf("finalEncoded[index++] = encodedInstance.%s__Encoded[j++];", fieldName))
-			b.Unindent()
-			b.P("}")
-		default:
-			// Non-message type
-			b.P("j = 0;")
-			b.P(fmt.Sprintf("while (j < encodedInstance.%s.length) {", fieldName))
-			b.Indent()
-			b.P(fmt.Sprintf("finalEncoded[index++] = encodedInstance.%s[j++];", fieldName))
-			b.Unindent()
-			b.P("}")
-		}
+	wireStr, err := toSolWireType(field)
+	if err != nil {
+		return err
 	}
-	b.P()
-
-	b.P("return finalEncoded;")
-	b.Unindent()
-	b.P("}")
-	b.P()
 
-	////////////////////////////////////
-	// Generate encoder for nested message
-	////////////////////////////////////
+	// Only embedded messages need the nesting depth
+	fieldDepthArg := ""
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		fieldDepthArg = depthArg
+	}
+	fieldEndArg := ""
+	if isEndPassed(field) {
+		fieldEndArg = ", end"
+	}
 
-	b.P(fmt.Sprintf("// Encode a nested %s, wrapped in key and length if non-default", structName))
-	b.P(fmt.Sprintf("function encodeNested(uint64 field_number, %s memory instance) internal pure returns (%s memory) {", structName, structNameEncodedNested))
+	b.P(fmt.Sprintf("// Field %s", field.GetName()))
+	b.P(fmt.Sprintf("if (wire_type != %s) {", wireStr))
 	b.Indent()
+	b.P("return (false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P(fmt.Sprintf("(success, pos) = decode_%d%s(pos, buf%s, instance%s);", fieldNumber, input.suffix, fieldEndArg, fieldDepthArg))
+	b.P("return (success, pos);")
 
-	b.P(fmt.Sprintf("%s memory wrapped;", structNameEncodedNested))
-	b.P()
+	return nil
+}
 
-	b.P("wrapped.nestedInstance = encode(instance);")
-	b.P()
+// generatePackedCount generates code that counts the elements of a packed
+// repeated field of length len starting at initial_pos, without decoding them.
+// On failure, the generated code returns fail.
+func generatePackedCount(fieldDescriptorType descriptorpb.FieldDescriptorProto_Type, fail string, b *WriteableBuffer) {
+	elementSize := toPackedElementSize(fieldDescriptorType)
+	if elementSize > 0 {
+		b.P("// Elements have a fixed size, so the length must be a multiple of it")
+		b.P(fmt.Sprintf("if (len %% %d != 0) {", elementSize))
+		b.Indent()
+		b.P(fmt.Sprintf("return %s;", fail))
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("uint64 cnt = len / %d;", elementSize))
+		b.P()
+		return
+	}
 
-	b.P("uint64 len = uint64(wrapped.nestedInstance.length);")
-	b.P("if (len > 0) {")
+	b.P("// Count the elements, each of which ends with a byte that has its highest bit clear")
+	b.P("uint64 cnt = 0;")
+	b.P("for (uint64 i = initial_pos; i < initial_pos + len; i++) {")
 	b.Indent()
-	b.P("wrapped.key = ProtobufLib.encode_key(field_number, 2);")
-	b.P("wrapped.length = ProtobufLib.encode_uint64(len);")
+	b.P("if ((uint8(buf[i]) & 0x80) == 0) {")
+	b.Indent()
+	b.P("cnt += 1;")
 	b.Unindent()
 	b.P("}")
-	b.P()
-
-	b.P("return wrapped;")
 	b.Unindent()
 	b.P("}")
 	b.P()
+}
 
-	return nil
+// solidityVersionString returns the Solidity version specifier of generated files.
+func (g *Generator) solidityVersionString() string {
+	if g.evmFlag == evmFlagCancun {
+		return SolidityCancunVersionString
+	}
+	if g.isCalldataDecoded() {
+		return SolidityCalldataVersionString
+	}
+	return SolidityVersionString
+}
+
+// toPackedElementSize returns the size in bytes of an element of a packed
+// repeated field, or 0 if elements are varints.
+func toPackedElementSize(fieldDescriptorType descriptorpb.FieldDescriptorProto_Type) uint64 {
+	switch fieldDescriptorType {
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return 4
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return 8
+	}
+
+	return 0
 }
 
 func checkSyntaxVersion(v string) error {
@@ -1582,5 +1919,5 @@ func toSolWireType(field *descriptorpb.FieldDescriptorProto) (string, error) {
 
 func toSolMessageOrEnumName(field *descriptorpb.FieldDescriptorProto) (string, error) {
 	// Names take the form ".name", so remove the leading period
-	return field.GetTypeName()[1:], nil
+	return trimWellKnownPackage(field.GetTypeName())[1:], nil
 }
@@ -0,0 +1,121 @@
+package generator
+
+import "fmt"
+
+// hashFunction is a hash function of which the generated hash functions of a
+// message's codec library are variants.
+type hashFunction struct {
+	// Suffix of the generated function names
+	suffix string
+	// Solidity builtin computing the hash of a bytes array
+	builtin string
+}
+
+var (
+	keccak256Hash = hashFunction{"", "keccak256"}
+	sha256Hash    = hashFunction{"_sha256", "sha256"}
+)
+
+// hashFunctions returns the hash functions selected by the hash parameter.
+func (g *Generator) hashFunctions() []hashFunction {
+	switch g.hashFlag {
+	case hashFlagKeccak256:
+		return []hashFunction{keccak256Hash}
+	case hashFlagSHA256:
+		return []hashFunction{sha256Hash}
+	case hashFlagAll:
+		return []hashFunction{keccak256Hash, sha256Hash}
+	}
+	return nil
+}
+
+// generateHashFunctions generates the functions of a message's codec library
+// that hash the canonical encoding of a message: hash<suffix>, which encodes an
+// instance then hashes it, and hash_encoded<suffix>, which checks that bytes
+// are a canonical encoding before hashing them.
+func (g *Generator) generateHashFunctions(structName string, b *WriteableBuffer) {
+	for _, hash := range g.hashFunctions() {
+		if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+			g.generateHash(structName, hash, b)
+		}
+		if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
+			for _, input := range g.decoderInputs() {
+				generateHashEncoded(structName, hash, input, b)
+			}
+		}
+	}
+}
+
+// generateHash generates the function hashing the encoding of an instance.
+//
+// The EVM can only hash contiguous memory, so the encoding is always written
+// out first. With hash_in_place, it is written to free memory that is released
+// after hashing, rather than to a new bytes array that stays allocated.
+func (g *Generator) generateHash(structName string, hash hashFunction, b *WriteableBuffer) {
+	b.P(fmt.Sprintf("// %s hash of the encoding of instance", hash.builtin))
+	b.P(fmt.Sprintf("function hash%s(%s memory instance) internal pure returns (bytes32 h) {", hash.suffix, structName))
+	b.Indent()
+
+	if !g.hashInPlace {
+		b.P(fmt.Sprintf("return %s(encode(instance));", hash.builtin))
+		b.Unindent()
+		b.P("}")
+		b.P()
+		return
+	}
+
+	b.P("// Free memory, released after hashing along with the sizes and the encoding")
+	b.P("uint256 free;")
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	b.P("free := mload(0x40)")
+	b.Unindent()
+	b.P("}")
+	b.P("uint64[] memory sizes = cached_sizes(instance);")
+	b.P("uint64 size = sizes[0];")
+	b.P()
+	b.P("// Encode into free memory, reserving it while encoding")
+	b.P("bytes memory buf;")
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	b.P("buf := mload(0x40)")
+	b.P("mstore(buf, size)")
+	b.P("mstore(0x40, add(add(buf, 32), and(add(size, 31), not(31))))")
+	b.Unindent()
+	b.P("}")
+	b.P("encode_at(0, buf, instance, sizes);")
+	b.P()
+	b.P(fmt.Sprintf("h = %s(buf);", hash.builtin))
+	b.P()
+	b.P("// Release the memory of the sizes and the encoding, nothing allocated since is kept")
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	b.P("mstore(0x40, free)")
+	b.Unindent()
+	b.P("}")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateHashEncoded generates the function hashing bytes that must be the
+// canonical encoding of a message.
+func generateHashEncoded(structName string, hash hashFunction, input decoderInput, b *WriteableBuffer) {
+	b.P(fmt.Sprintf("// %s hash of buf, returns false if it isn't the canonical encoding of a %s", hash.builtin, structName))
+	b.P(fmt.Sprintf("function hash_encoded%s%s(%s buf) internal pure returns (bool, bytes32) {", hash.suffix, input.suffix, input.bufType))
+	b.Indent()
+	b.P(fmt.Sprintf("(bool success, , ) = decode%s(0, buf, uint64(buf.length));", input.suffix))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, 0);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P(fmt.Sprintf("return (true, %s(buf));", hash.builtin))
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
@@ -0,0 +1,268 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// generateIterators generates iterators over the repeated fields of a message,
+// which decode one element at a time from the encoded message rather than
+// allocating an array of all elements. Each iterator has an iter_<field>
+// function returning a cursor on the first element, and a next_<field>
+// function returning the element under the cursor then advancing it.
+func (g *Generator) generateIterators(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	repeated := []*descriptorpb.FieldDescriptorProto{}
+	for _, field := range fields {
+		if isFieldRepeated(field) {
+			repeated = append(repeated, field)
+		}
+	}
+	if len(repeated) == 0 {
+		return nil
+	}
+
+	b.P("// Position of an iterator over the elements of a repeated field")
+	b.P("struct Cursor {")
+	b.Indent()
+	b.P("bytes buf;")
+	b.P("// Position of the next element")
+	b.P("uint64 pos;")
+	b.P("// End of a packed field, or of the message for a repeated message field")
+	b.P("uint64 end;")
+	b.P("// Whether there is a next element")
+	b.P("bool more;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	for _, field := range repeated {
+		err := g.generateIterator(structName, field, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateIterator generates the iter_<field> and next_<field> functions of a
+// repeated field.
+func (g *Generator) generateIterator(structName string, field *descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+	fieldNumber := field.GetNumber()
+	isMessage := field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	returnType, err := toSolReturnType(field)
+	if err != nil {
+		return errors.New(err.Error() + ": " + structName + "." + fieldName)
+	}
+
+	b.P(fmt.Sprintf("// Iterate over %s.%s", structName, fieldName))
+	b.P(fmt.Sprintf("function iter_%s(bytes memory buf) internal pure returns (bool, Cursor memory) {", fieldName))
+	b.Indent()
+	b.P(fmt.Sprintf("return iter_%s(0, buf, uint64(buf.length));", fieldName))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function iter_%s(uint64 initial_pos, bytes memory buf, uint64 len) internal pure returns (bool, Cursor memory) {", fieldName))
+	b.Indent()
+	b.P("Cursor memory cursor;")
+	b.P("cursor.buf = buf;")
+	b.P()
+	b.P("bool success;")
+	b.P("bool found;")
+	b.P("uint64 pos;")
+	b.P(fmt.Sprintf("(success, found, pos) = find_field(initial_pos, buf, len, %d);", fieldNumber))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, cursor);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("// Absent field has no elements")
+	b.P("if (!found) {")
+	b.Indent()
+	b.P("return (true, cursor);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	if isMessage {
+		b.P("// Elements are consecutive fields, each checked as it is reached")
+		b.P("cursor.pos = pos;")
+		b.P("cursor.end = initial_pos + len;")
+	} else {
+		b.P("uint64 field_len;")
+		b.P("(success, pos, field_len) = ProtobufLib.decode_length_delimited(pos, buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, cursor);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Empty packed array must be omitted")
+		b.P("if (field_len == 0) {")
+		b.Indent()
+		b.P("return (false, cursor);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if size := toPackedElementSize(field.GetType()); size > 0 {
+			b.P("// Packed length must be a multiple of the element size")
+			b.P(fmt.Sprintf("if (field_len %% %d != 0) {", size))
+			b.Indent()
+			b.P("return (false, cursor);")
+			b.Unindent()
+			b.P("}")
+			b.P()
+		}
+
+		b.P("// Field must be within the message")
+		b.P("if (pos + field_len > initial_pos + len) {")
+		b.Indent()
+		b.P("return (false, cursor);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("cursor.pos = pos;")
+		b.P("cursor.end = pos + field_len;")
+	}
+	b.P("cursor.more = true;")
+	b.P()
+	b.P("return (true, cursor);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Next element of the iterator, returns whether the encoding is valid and whether there was a next element")
+	b.P(fmt.Sprintf("function next_%s(Cursor memory cursor) internal pure returns (bool, bool, %s) {", fieldName, returnType))
+	b.Indent()
+	b.P(fmt.Sprintf("%s v;", returnType))
+	b.P()
+	b.P("if (!cursor.more) {")
+	b.Indent()
+	b.P("return (true, false, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("// Iteration stops at the first invalid element")
+	b.P("cursor.more = false;")
+	b.P()
+	b.P("bool success;")
+
+	if isMessage {
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P("uint64 nested_len;")
+		b.P("(success, cursor.pos, nested_len) = ProtobufLib.decode_embedded_message(cursor.pos, cursor.buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Element must be within the message")
+		b.P("if (cursor.pos + nested_len > cursor.end) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P(fmt.Sprintf("(success, cursor.pos, v) = %sCodec.decode(cursor.pos, cursor.buf, nested_len);", fieldTypeName))
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// There is a next element if the next field has the same number")
+		b.P("if (cursor.pos < cursor.end) {")
+		b.Indent()
+		b.P("uint64 pos;")
+		b.P("uint64 field_number;")
+		b.P("ProtobufLib.WireType wire_type;")
+		b.P("(success, pos, field_number, wire_type) = ProtobufLib.decode_key(cursor.pos, cursor.buf);")
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("if (field_number == %d) {", fieldNumber))
+		b.Indent()
+		b.P("if (wire_type != ProtobufLib.WireType.LengthDelimited) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P("cursor.pos = pos;")
+		b.P("cursor.more = true;")
+		b.Unindent()
+		b.P("}")
+		b.Unindent()
+		b.P("}")
+		b.P()
+	} else {
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+			b.P("int32 e;")
+			b.P("(success, cursor.pos, e) = ProtobufLib.decode_enum(cursor.pos, cursor.buf);")
+		} else {
+			fieldDecodeType, err := typeToDecodeSol(field.GetType())
+			if err != nil {
+				return errors.New(err.Error() + ": " + structName + "." + fieldName)
+			}
+			b.P(fmt.Sprintf("(success, cursor.pos, v) = ProtobufLib.decode_%s(cursor.pos, cursor.buf);", fieldDecodeType))
+		}
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Element must be within the packed field")
+		b.P("if (cursor.pos > cursor.end) {")
+		b.Indent()
+		b.P("return (false, false, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+
+			b.P("// Check that value is within enum range")
+			b.P(fmt.Sprintf("if (e < 0 || e > %d) {", g.enumMaxes[fieldTypeName]))
+			b.Indent()
+			b.P("return (false, false, v);")
+			b.Unindent()
+			b.P("}")
+			b.P(fmt.Sprintf("v = %s(e);", fieldTypeName))
+			b.P()
+		}
+
+		b.P("cursor.more = cursor.pos < cursor.end;")
+		b.P()
+	}
+
+	b.P("return (true, true, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
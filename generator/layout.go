@@ -0,0 +1,68 @@
+package generator
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// memberOrder returns the indexes of fields in the order of the members of
+// their message's struct.
+//
+// With the packed layout, members that take a whole storage slot come first,
+// followed by the others by decreasing size, so that consecutive members share
+// 32-byte slots. Ties keep field order. Since sizes are powers of two, no member
+// straddles a slot boundary.
+func (g *Generator) memberOrder(fields []*descriptorpb.FieldDescriptorProto) []int {
+	order := make([]int, len(fields))
+	for i := range fields {
+		order[i] = i
+	}
+
+	if g.layoutFlag == layoutFlagPacked {
+		sort.SliceStable(order, func(a, b int) bool {
+			return toStorageSize(fields[order[a]]) > toStorageSize(fields[order[b]])
+		})
+	}
+
+	return order
+}
+
+// memberIndexes returns the index of the struct member of each field, for code
+// that addresses members by position in memory rather than by name.
+func (g *Generator) memberIndexes(fields []*descriptorpb.FieldDescriptorProto) []int {
+	indexes := make([]int, len(fields))
+	for member, i := range g.memberOrder(fields) {
+		indexes[i] = member
+	}
+
+	return indexes
+}
+
+// toStorageSize returns the number of bytes a field's member takes in storage.
+func toStorageSize(field *descriptorpb.FieldDescriptorProto) int {
+	if isFieldRepeated(field) {
+		return 32
+	}
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return 8
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return 4
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return 1
+	}
+
+	// Strings, bytes and embedded messages start a new slot
+	return 32
+}
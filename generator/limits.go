@@ -0,0 +1,131 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeLimits holds the limits enforced by generated decoders. A value of 0
+// means the limit is not enforced.
+type decodeLimits struct {
+	// Maximum number of elements in a repeated field
+	maxRepeated uint64
+	// Maximum length in bytes of a string or bytes field
+	maxLength uint64
+	// Maximum nesting depth of embedded messages
+	maxDepth uint64
+	// Maximum length in bytes of an encoded message
+	maxSize uint64
+}
+
+// merge returns limits where any limit set in o overrides the one in l.
+func (l decodeLimits) merge(o decodeLimits) decodeLimits {
+	if o.maxRepeated > 0 {
+		l.maxRepeated = o.maxRepeated
+	}
+	if o.maxLength > 0 {
+		l.maxLength = o.maxLength
+	}
+	if o.maxDepth > 0 {
+		l.maxDepth = o.maxDepth
+	}
+	if o.maxSize > 0 {
+		l.maxSize = o.maxSize
+	}
+	return l
+}
+
+// isLimitParameter returns true if key names a limit parameter, either global
+// (e.g. max_repeated) or per-message (e.g. max_repeated.Message).
+func isLimitParameter(key string) bool {
+	switch strings.SplitN(key, ".", 2)[0] {
+	case "max_repeated", "max_length", "max_depth", "max_size":
+		return true
+	}
+	return false
+}
+
+// parseLimitParameter parses a limit parameter of the form <limit>=<N> (global
+// limit) or <limit>.<Message>=<N> (per-message limit).
+func (g *Generator) parseLimitParameter(key string, value string) error {
+	messageName := ""
+	if i := strings.Index(key, "."); i >= 0 {
+		key, messageName = key[:i], key[i+1:]
+		if len(messageName) == 0 {
+			return errors.New("missing message name in limit " + key)
+		}
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid limit %s=%s, must be a positive integer", key, value)
+	}
+
+	limits := g.limits
+	if len(messageName) > 0 {
+		limits = g.messageLimits[messageName]
+	}
+
+	switch key {
+	case "max_repeated":
+		limits.maxRepeated = n
+	case "max_length":
+		limits.maxLength = n
+	case "max_depth":
+		limits.maxDepth = n
+	case "max_size":
+		limits.maxSize = n
+	}
+
+	if len(messageName) > 0 {
+		g.messageLimits[messageName] = limits
+	} else {
+		g.limits = limits
+	}
+
+	return nil
+}
+
+// limitsFor returns the limits that apply to a message.
+func (g *Generator) limitsFor(structName string) decodeLimits {
+	return g.limits.merge(g.messageLimits[structName])
+}
+
+// checkMessageLimits checks that per-message limits refer to known messages.
+func (g *Generator) checkMessageLimits() error {
+	for messageName := range g.messageLimits {
+		if _, ok := g.messages[messageName]; !ok {
+			return errors.New("limit set for unknown message: " + messageName)
+		}
+	}
+
+	return nil
+}
+
+// isDepthTracked returns true if decoders must keep track of nesting depth.
+func (g *Generator) isDepthTracked() bool {
+	if g.limits.maxDepth > 0 {
+		return true
+	}
+	for _, limits := range g.messageLimits {
+		if limits.maxDepth > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// generateLimitCheck generates a check that reverts if cond holds. Exceeding a
+// limit reverts rather than returning false so callers can tell it apart from
+// malformed input.
+func generateLimitCheck(cond string, reason string, b *WriteableBuffer) {
+	b.P("// Enforce decoding limit")
+	b.P(fmt.Sprintf("if (%s) {", cond))
+	b.Indent()
+	b.P(fmt.Sprintf("revert(\"%s\");", reason))
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
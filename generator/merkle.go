@@ -0,0 +1,133 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// checkMerkle checks that the other parameters support Merkle roots, whose
+// leaves are computed by the encoder.
+func (g *Generator) checkMerkle() error {
+	if !g.merkle {
+		return nil
+	}
+
+	if g.generateFlag == generateFlagDecoder {
+		return fmt.Errorf("merkle requires generate %s or %s", fromGenerateFlag(generateFlagAll), fromGenerateFlag(generateFlagEncoder))
+	}
+
+	return nil
+}
+
+// generateMerkleFunctions generates the functions of a message's codec library
+// that compute the Merkle root of its fields and verify proofs of single
+// fields, as CometBFT does for Header.Hash().
+//
+// There is one leaf per field, in field number order. The leaf of an embedded
+// message is its encoding, and the leaf of any other field is the encoding of a
+// message holding the field's value as field 1, as CometBFT's cdcEncode does
+// with the well-known wrapper types. Default values have empty leaves.
+func (g *Generator) generateMerkleFunctions(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	b.P("// Merkle root of the fields of instance, with SHA-256 and the leaf and inner prefixes of RFC 6962")
+	b.P(fmt.Sprintf("function merkle_root(%s memory instance) internal pure returns (bytes32) {", structName))
+	b.Indent()
+	b.P(fmt.Sprintf("bytes32[] memory leaves = new bytes32[](%d);", len(fields)))
+	for i, field := range fields {
+		b.P(fmt.Sprintf("leaves[%d] = ProtobufSupportLib.leaf_hash(merkle_leaf_%d(instance));", i, field.GetNumber()))
+	}
+	b.P()
+	b.P("return ProtobufSupportLib.merkle_root(leaves);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Verify that leaf is the leaf of the field with number field_number in the Merkle root of a message")
+	b.P("function verify_field_proof(bytes32 root, uint64 field_number, bytes memory leaf, bytes32[] memory aunts) internal pure returns (bool) {")
+	b.Indent()
+	b.P(fmt.Sprintf("if (field_number == 0 || field_number > %d) {", len(fields)))
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P(fmt.Sprintf("return ProtobufSupportLib.verify_merkle_proof(root, field_number - 1, %d, ProtobufSupportLib.leaf_hash(leaf), aunts);", len(fields)))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	for _, field := range fields {
+		err := g.generateMerkleLeaf(structName, field, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateMerkleLeaf generates the function returning the Merkle leaf of a
+// field, along with the functions encoding the field as field 1 if it isn't an
+// embedded message.
+func (g *Generator) generateMerkleLeaf(structName string, field *descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+	fieldNumber := field.GetNumber()
+	v := fmt.Sprintf("instance.%s", fieldName)
+
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && !isFieldRepeated(field) {
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		b.P(fmt.Sprintf("// Merkle leaf of %s.%s, the encoding of the embedded message", structName, fieldName))
+		b.P(fmt.Sprintf("function merkle_leaf_%d(%s memory instance) internal pure returns (bytes memory) {", fieldNumber, structName))
+		b.Indent()
+		b.P(fmt.Sprintf("return %sCodec.encode(%s);", fieldTypeName, v))
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		return nil
+	}
+
+	wireType, err := toWireType(field)
+	if err != nil {
+		return errors.New(err.Error() + ": " + structName + "." + fieldName)
+	}
+	key := 1<<3 | wireType
+
+	b.P(fmt.Sprintf("// Merkle leaf of %s.%s, the encoding of the field as field 1", structName, fieldName))
+	b.P(fmt.Sprintf("function merkle_leaf_%d(%s memory instance) internal pure returns (bytes memory) {", fieldNumber, structName))
+	b.Indent()
+	b.P(fmt.Sprintf("bytes memory buf = new bytes(merkle_leaf_size_%d(instance));", fieldNumber))
+	b.P(fmt.Sprintf("merkle_leaf_encode_%d(0, buf, instance);", fieldNumber))
+	b.P()
+	b.P("return buf;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function merkle_leaf_size_%d(%s memory instance) internal pure returns (uint64) {", fieldNumber, structName))
+	b.Indent()
+	err = g.generateFieldSize(structName, field, v, varintSize(key), b)
+	if err != nil {
+		return err
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function merkle_leaf_encode_%d(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (uint64) {", fieldNumber, structName))
+	b.Indent()
+	err = g.generateFieldEncoder(structName, field, v, key, b)
+	if err != nil {
+		return err
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
@@ -0,0 +1,421 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// toFileLibName returns the name of a library of a .proto file with the given
+// suffix, e.g. AllFeaturesHelpers for all_features.proto and Helpers.
+func toFileLibName(fileName string, suffix string) string {
+	base := strings.TrimSuffix(filepath.Base(fileName), ".proto")
+
+	name := ""
+	for _, part := range strings.FieldsFunc(base, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return name + suffix
+}
+
+// toHelperLibName returns the name of the helper library of a .proto file,
+// e.g. AllFeaturesHelpers for all_features.proto.
+func toHelperLibName(fileName string) string {
+	return toFileLibName(fileName, "Helpers")
+}
+
+// isHelperUsed returns true if a field is decoded and encoded by functions of
+// the file's helper library, which are shared by all fields of the same type.
+func (g *Generator) isHelperUsed(field *descriptorpb.FieldDescriptorProto) bool {
+	if g.optimizeFlag != optimizeFlagSize {
+		return false
+	}
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return false
+	}
+
+	return true
+}
+
+// toHelperKind returns the name used for the helper functions of a field's
+// type, i.e. the enum name or the primitive type name.
+func toHelperKind(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+		return toSolMessageOrEnumName(field)
+	}
+
+	return typeToDecodeSol(field.GetType())
+}
+
+// toHelperValueType returns the Solidity type of a single value of a field
+// handled by helper functions.
+func toHelperValueType(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+		return toSolMessageOrEnumName(field)
+	}
+
+	return typeToSol(field.GetType())
+}
+
+// generateHelperDecode generates the body of a field decoder that calls a
+// helper function.
+func (g *Generator) generateHelperDecode(structName string, field *descriptorpb.FieldDescriptorProto, input decoderInput, limits decodeLimits, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+
+	kind, err := toHelperKind(field)
+	if err != nil {
+		return errors.New(err.Error() + ": " + structName + "." + fieldName)
+	}
+	valueType, err := toHelperValueType(field)
+	if err != nil {
+		return errors.New(err.Error() + ": " + structName + "." + fieldName)
+	}
+
+	if isFieldRepeated(field) {
+		b.P(fmt.Sprintf("%s[] memory v;", valueType))
+		b.P(fmt.Sprintf("(success, pos, v) = %s.decode_packed_%s%s(pos, buf, %d);", g.helperLibName, kind, input.suffix, limits.maxRepeated))
+	} else {
+		b.P(fmt.Sprintf("%s v;", valueType))
+		b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s%s(pos, buf);", g.helperLibName, kind, input.suffix))
+	}
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("instance.%s = v;", fieldName))
+	b.P()
+
+	return nil
+}
+
+// generateHelperLib generates the helper library of a .proto file, with one
+// set of functions per type of field handled by helpers.
+func (g *Generator) generateHelperLib(protoFile *descriptorpb.FileDescriptorProto, b *WriteableBuffer) error {
+	// Fields with a distinct type, in order of appearance
+	singularFields := []*descriptorpb.FieldDescriptorProto{}
+	packedFields := []*descriptorpb.FieldDescriptorProto{}
+	seen := make(map[string]bool)
+	for _, descriptor := range fileMessages(protoFile) {
+		for _, field := range descriptor.GetField() {
+			if !g.isHelperUsed(field) {
+				continue
+			}
+
+			kind, err := toHelperKind(field)
+			if err != nil {
+				return errors.New(err.Error() + ": " + descriptor.GetName() + "." + field.GetName())
+			}
+			key := kind
+			if isFieldRepeated(field) {
+				key = "packed_" + kind
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if isFieldRepeated(field) {
+				packedFields = append(packedFields, field)
+			} else {
+				singularFields = append(singularFields, field)
+			}
+		}
+	}
+
+	if len(singularFields) == 0 && len(packedFields) == 0 {
+		return nil
+	}
+
+	b.P(fmt.Sprintf("library %s {", g.helperLibName))
+	b.Indent()
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
+		for _, input := range g.decoderInputs() {
+			for _, field := range singularFields {
+				err := g.generateSingularHelperDecoder(field, input, b)
+				if err != nil {
+					return err
+				}
+			}
+			for _, field := range packedFields {
+				err := g.generatePackedHelperDecoder(field, input, b)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		for _, field := range packedFields {
+			err := g.generatePackedHelperEncoder(field, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateSingularHelperDecoder generates a helper that decodes a single
+// numeric, bool or enum value, which must not be the default value.
+func (g *Generator) generateSingularHelperDecoder(field *descriptorpb.FieldDescriptorProto, input decoderInput, b *WriteableBuffer) error {
+	kind, err := toHelperKind(field)
+	if err != nil {
+		return err
+	}
+	valueType, err := toHelperValueType(field)
+	if err != nil {
+		return err
+	}
+
+	b.P(fmt.Sprintf("function decode_%s%s(uint64 pos, %s buf) internal pure returns (bool, uint64, %s) {", kind, input.suffix, input.bufType, valueType))
+	b.Indent()
+	b.P("bool success;")
+	b.P(fmt.Sprintf("%s v;", valueType))
+	b.P()
+
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+		b.P("int32 e;")
+		b.P(fmt.Sprintf("(success, pos, e) = %s.decode_enum(pos, buf);", input.lib))
+		b.P("if (!success) {")
+		b.Indent()
+		b.P("return (false, pos, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Default value must be omitted")
+		b.P("if (e == 0) {")
+		b.Indent()
+		b.P("return (false, pos, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Check that value is within enum range")
+		b.P(fmt.Sprintf("if (e < 0 || e > %d) {", g.enumMaxes[kind]))
+		b.Indent()
+		b.P("return (false, pos, v);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P(fmt.Sprintf("return (true, pos, %s(e));", valueType))
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		return nil
+	}
+
+	b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s(pos, buf);", input.lib, kind))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Default value must be omitted")
+	if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BOOL {
+		b.P("if (v == false) {")
+	} else {
+		b.P("if (v == 0) {")
+	}
+	b.Indent()
+	b.P("return (false, pos, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("return (true, pos, v);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generatePackedHelperDecoder generates a helper that decodes a packed array
+// of numeric, bool or enum values, which must not be empty. If max_repeated is
+// not zero and the array has more elements, the helper reverts before
+// allocating memory. It is shared by fields of all messages, so the revert
+// reason doesn't name the field.
+func (g *Generator) generatePackedHelperDecoder(field *descriptorpb.FieldDescriptorProto, input decoderInput, b *WriteableBuffer) error {
+	kind, err := toHelperKind(field)
+	if err != nil {
+		return err
+	}
+	valueType, err := toHelperValueType(field)
+	if err != nil {
+		return err
+	}
+	isEnum := field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM
+
+	b.P(fmt.Sprintf("function decode_packed_%s%s(uint64 pos, %s buf, uint64 max_repeated) internal pure returns (bool, uint64, %s[] memory) {", kind, input.suffix, input.bufType, valueType))
+	b.Indent()
+	b.P("bool success;")
+	b.P(fmt.Sprintf("%s[] memory values;", valueType))
+	b.P()
+
+	b.P("uint64 len;")
+	b.P(fmt.Sprintf("(success, pos, len) = %s.decode_length_delimited(pos, buf);", input.lib))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Empty packed array must be omitted")
+	b.P("if (len == 0) {")
+	b.Indent()
+	b.P("return (false, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("uint64 initial_pos = pos;")
+	b.P()
+
+	b.P("// Sanity checks")
+	b.P("if (pos + len < pos) {")
+	b.Indent()
+	b.P("return (false, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	generatePackedCount(field.GetType(), "(false, pos, values)", b)
+
+	generateLimitCheck("max_repeated != 0 && cnt > max_repeated", "max_repeated exceeded", b)
+
+	b.P("// Allocated memory")
+	b.P(fmt.Sprintf("values = new %s[](cnt);", valueType))
+	b.P()
+
+	b.P("// Parse the elements")
+	b.P("for (uint64 i = 0; i < cnt; i++) {")
+	b.Indent()
+	if isEnum {
+		b.P("int32 v;")
+		b.P(fmt.Sprintf("(success, pos, v) = %s.decode_enum(pos, buf);", input.lib))
+	} else {
+		b.P(fmt.Sprintf("%s v;", valueType))
+		b.P(fmt.Sprintf("(success, pos, v) = %s.decode_%s(pos, buf);", input.lib, kind))
+	}
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	if isEnum {
+		b.P("// Check that value is within enum range")
+		b.P(fmt.Sprintf("if (v < 0 || v > %d) {", g.enumMaxes[kind]))
+		b.Indent()
+		b.P("return (false, pos, values);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P(fmt.Sprintf("values[i] = %s(v);", valueType))
+	} else {
+		b.P("values[i] = v;")
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decoding must have consumed len bytes")
+	b.P("if (pos != initial_pos + len) {")
+	b.Indent()
+	b.P("return (false, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("return (true, pos, values);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generatePackedHelperEncoder generates helpers that compute the size of and
+// encode a packed array of numeric, bool or enum values.
+func (g *Generator) generatePackedHelperEncoder(field *descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	kind, err := toHelperKind(field)
+	if err != nil {
+		return err
+	}
+	valueType, err := toHelperValueType(field)
+	if err != nil {
+		return err
+	}
+
+	b.P("// Number of bytes needed to encode the elements of values")
+	b.P(fmt.Sprintf("function size_packed_%s(%s[] memory values) internal pure returns (uint64) {", kind, valueType))
+	b.Indent()
+	err = g.generatePackedSize(kind, field, "values", b)
+	if err != nil {
+		return err
+	}
+	b.P("return len;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	valueWrite, err := toSolValueWrite(field, "values[i]")
+	if err != nil {
+		return err
+	}
+
+	b.P("// Encode values with key into buf at pos, which must have enough bytes, returns the new pos")
+	b.P(fmt.Sprintf("function encode_packed_%s(uint64 pos, bytes memory buf, uint64 key, %s[] memory values) internal pure returns (uint64) {", kind, valueType))
+	b.Indent()
+	b.P("// Empty packed array is omitted")
+	b.P("if (values.length == 0) {")
+	b.Indent()
+	b.P("return pos;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("uint64 len = size_packed_%s(values);", kind))
+	b.P("pos = ProtobufSupportLib.write_varint(pos, buf, key);")
+	b.P("pos = ProtobufSupportLib.write_varint(pos, buf, len);")
+	b.P("for (uint256 i = 0; i < values.length; i++) {")
+	b.Indent()
+	b.P(fmt.Sprintf("pos = %s;", valueWrite))
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return pos;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
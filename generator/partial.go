@@ -0,0 +1,157 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// maxPartialFields is the maximum number of fields of a message decoded
+// partially, as field masks are uint256 with one bit per field.
+const maxPartialFields = 256
+
+// checkPartial checks that the other parameters support partial decoders,
+// which reuse the field decoders of the memory decoder.
+func (g *Generator) checkPartial() error {
+	if !g.partial {
+		return nil
+	}
+
+	if g.inputFlag == inputFlagCalldata {
+		return fmt.Errorf("partial decoders do not support input %s", fromInputFlag(inputFlagCalldata))
+	}
+
+	return nil
+}
+
+// toMaskName returns the name of the constant holding the field mask of a
+// field, e.g. MASK_OPTIONAL_INT32 for optional_int32.
+func toMaskName(field *descriptorpb.FieldDescriptorProto) string {
+	return "MASK_" + strings.ToUpper(field.GetName())
+}
+
+// generatePartialDecoder generates decode_partial functions, which decode only
+// the fields selected by a mask with bit field_number - 1 set for each selected
+// field. Other fields are skipped by wire type, after their field number and
+// wire type are checked like the full decoder does, so the encoding must still
+// be canonical up to the contents of skipped fields.
+func (g *Generator) generatePartialDecoder(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	if len(fields) > maxPartialFields {
+		return errors.New("too many fields for partial decoder: " + structName)
+	}
+
+	limits := g.limitsFor(structName)
+
+	// Partially decoded messages are top-level, selected embedded messages are
+	// decoded fully
+	depthArg := ""
+	if g.isDepthTracked() {
+		depthArg = ", 0"
+	}
+
+	b.P("// Field masks for decode_partial, which can be combined with |")
+	for _, field := range fields {
+		b.P(fmt.Sprintf("uint256 constant %s = 1 << %d;", toMaskName(field), field.GetNumber()-1))
+	}
+	b.P()
+
+	b.P("// Decode the fields of buf selected by mask, leaving the others to their default value")
+	b.P(fmt.Sprintf("function decode_partial(bytes memory buf, uint256 mask) internal pure returns (bool, %s memory) {", structName))
+	b.Indent()
+	b.P(fmt.Sprintf("(bool success, , %s memory instance) = decode_partial(0, buf, uint64(buf.length), mask);", structName))
+	b.P()
+	b.P("return (success, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function decode_partial(uint64 initial_pos, bytes memory buf, uint64 len, uint256 mask) internal pure returns (bool, uint64, %s memory) {", structName))
+	b.Indent()
+	b.P("// Message instance")
+	b.P(fmt.Sprintf("%s memory instance;", structName))
+	b.P("// Previous field number")
+	b.P("uint64 previous_field_number = 0;")
+	b.P("// Current position in the buffer")
+	b.P("uint64 pos = initial_pos;")
+	b.P()
+
+	b.P("// Sanity checks")
+	b.P("if (pos + len < pos) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	if limits.maxSize > 0 {
+		generateLimitCheck(fmt.Sprintf("len > %d", limits.maxSize), structName+": max_size exceeded", b)
+	}
+
+	b.P("while (pos - initial_pos < len) {")
+	b.Indent()
+	b.P("// Decode the key (field number and wire type)")
+	b.P("bool success;")
+	b.P("uint64 field_number;")
+	b.P("ProtobufLib.WireType wire_type;")
+	b.P("(success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Check that the field number is within bounds and the wire type is correct")
+	b.P("if (!check_key(field_number, wire_type)) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Check that the field number of monotonically increasing")
+	b.P("if (field_number <= previous_field_number) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("if ((mask >> (field_number - 1)) & 1 == 0) {")
+	b.Indent()
+	b.P("(success, pos) = ProtobufSupportLib.skip_field(pos, buf, wire_type);")
+	b.Unindent()
+	b.P("} else {")
+	b.Indent()
+	b.P(fmt.Sprintf("(success, pos) = decode_field(pos, buf, initial_pos + len, field_number, wire_type, instance%s);", depthArg))
+	b.Unindent()
+	b.P("}")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("previous_field_number = field_number;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decoding must have consumed len bytes")
+	b.P("if (pos != initial_pos + len) {")
+	b.Indent()
+	b.P("return (false, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("return (true, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
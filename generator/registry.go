@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// checkRegistry checks that the other parameters support registries, which
+// decode messages from memory.
+func (g *Generator) checkRegistry() error {
+	if !g.registry {
+		return nil
+	}
+
+	if g.generateFlag == generateFlagEncoder {
+		return fmt.Errorf("registry requires generate %s or %s", fromGenerateFlag(generateFlagAll), fromGenerateFlag(generateFlagDecoder))
+	}
+	if g.inputFlag == inputFlagCalldata {
+		return fmt.Errorf("registry does not support input %s", fromInputFlag(inputFlagCalldata))
+	}
+
+	return nil
+}
+
+// toRegistryLibName returns the name of the registry library of a .proto file,
+// e.g. AllFeaturesRegistry for all_features.proto.
+func toRegistryLibName(fileName string) string {
+	return toFileLibName(fileName, "Registry")
+}
+
+// toSnakeCase converts a message name to snake case, e.g. OtherMessage to
+// other_message.
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+
+	out := []rune{}
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				out = append(out, '_')
+			}
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+
+	return string(out)
+}
+
+// generateRegistry generates the registry library of a .proto file, which
+// decodes the value of a google.protobuf.Any as the message of the file named
+// by its type URL. The result is tagged with the kind of message decoded.
+func (g *Generator) generateRegistry(protoFile *descriptorpb.FileDescriptorProto, b *WriteableBuffer) {
+	messages := protoFile.GetMessageType()
+	if len(messages) == 0 {
+		return
+	}
+
+	b.P(fmt.Sprintf("// Messages of %s, decoded by type URL", protoFile.GetName()))
+	b.P(fmt.Sprintf("library %s {", toRegistryLibName(protoFile.GetName())))
+	b.Indent()
+
+	b.P("// Message type of a decoded value")
+	b.P("enum Kind {")
+	b.Indent()
+	b.P("UNKNOWN,")
+	for i, descriptor := range messages {
+		separator := ","
+		if i == len(messages)-1 {
+			separator = ""
+		}
+		b.P(strings.ToUpper(toSnakeCase(descriptor.GetName())) + separator)
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decoded value, with only the member of its kind set")
+	b.P("struct Unpacked {")
+	b.Indent()
+	b.P("Kind kind;")
+	for _, descriptor := range messages {
+		b.P(fmt.Sprintf("%s %s;", descriptor.GetName(), toSnakeCase(descriptor.GetName())))
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decode value as the message named by the last segment of type_url, as in google.protobuf.Any.")
+	b.P("// Unknown type URLs are rejected if strict, and have kind UNKNOWN otherwise.")
+	b.P("function decode_any(string memory type_url, bytes memory value, bool strict) internal pure returns (bool, Unpacked memory) {")
+	b.Indent()
+	b.P("Unpacked memory unpacked;")
+	b.P()
+	b.P(fmt.Sprintf("(bool success, bytes32 type_name) = %s.type_name_hash(type_url);", supportLibName))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, unpacked);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	for _, descriptor := range messages {
+		name := descriptor.GetName()
+
+		b.P(fmt.Sprintf("if (type_name == keccak256(\"%s\")) {", name))
+		b.Indent()
+		b.P(fmt.Sprintf("unpacked.kind = Kind.%s;", strings.ToUpper(toSnakeCase(name))))
+		b.P(fmt.Sprintf("(success, , unpacked.%s) = %sCodec.decode(0, value, uint64(value.length));", toSnakeCase(name), name))
+		b.P("return (success, unpacked);")
+		b.Unindent()
+		b.P("}")
+	}
+	b.P()
+	b.P("return (!strict, unpacked);")
+	b.Unindent()
+	b.P("}")
+
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
@@ -0,0 +1,113 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// typeURLPrefix is the prefix of the type URLs of messages, as used by
+// google.protobuf.Any. Packages are forbidden except for well-known types, so
+// the message name usually follows.
+const typeURLPrefix = "type.googleapis.com/"
+
+// toTypeURL returns the type URL of a message, with the fully-qualified name
+// of the message.
+func (g *Generator) toTypeURL(structName string) string {
+	if packageName := g.packages[structName]; len(packageName) > 0 {
+		return typeURLPrefix + packageName + "." + structName
+	}
+	return typeURLPrefix + structName
+}
+
+// schemaVersion is the version of the canonical descriptor format hashed into
+// schema hashes, which changes if the format does.
+const schemaVersion = "protobuf3-solidity/schema/v1"
+
+// schemaHash returns the schema hash of a message, the SHA-256 hash of its
+// canonical descriptor.
+//
+// The canonical descriptor has a header line with the format version, then one
+// line per field in field number order: "<number> <label> <type>", where label
+// is "singular" or "repeated" and type is the protobuf type name. Enums are
+// "enum:<max value>" and embedded messages "message:<schema hash in hex>".
+// Messages embedded recursively are "message:back:<depth>" instead, where depth
+// is the number of messages between the field and the message it refers to,
+// in the path of messages being hashed, 0 being the message of the field.
+// Names aren't part of it, as they aren't part of the encoding.
+func (g *Generator) schemaHash(structName string, path []string) ([32]byte, error) {
+	path = append(path, structName)
+
+	descriptor, ok := g.messages[structName]
+	if !ok {
+		return [32]byte{}, errors.New("unknown message: " + structName)
+	}
+
+	lines := []string{schemaVersion}
+	for _, field := range descriptor.GetField() {
+		label := "singular"
+		if isFieldRepeated(field) {
+			label = "repeated"
+		}
+
+		fieldType := strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+		switch field.GetType() {
+		case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return [32]byte{}, err
+			}
+			fieldType = fmt.Sprintf("enum:%d", g.enumMaxes[fieldTypeName])
+		case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return [32]byte{}, err
+			}
+			if depth := backReference(path, fieldTypeName); depth >= 0 {
+				fieldType = fmt.Sprintf("message:back:%d", depth)
+				break
+			}
+			nestedHash, err := g.schemaHash(fieldTypeName, path)
+			if err != nil {
+				return [32]byte{}, err
+			}
+			fieldType = fmt.Sprintf("message:%x", nestedHash)
+		}
+
+		lines = append(lines, fmt.Sprintf("%d %s %s", field.GetNumber(), label, fieldType))
+	}
+
+	return sha256.Sum256([]byte(strings.Join(lines, "\n") + "\n")), nil
+}
+
+// backReference returns the depth of a message in the path of messages being
+// hashed, counting from the last one, or -1 if it isn't in the path.
+func backReference(path []string, structName string) int {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == structName {
+			return len(path) - 1 - i
+		}
+	}
+
+	return -1
+}
+
+// generateSchemaConstants generates the constants of a message's codec library
+// that identify its schema.
+func (g *Generator) generateSchemaConstants(structName string, b *WriteableBuffer) error {
+	hash, err := g.schemaHash(structName, nil)
+	if err != nil {
+		return err
+	}
+
+	b.P("// Hash of the canonical descriptor of the message, which changes with its encoding")
+	b.P(fmt.Sprintf("bytes32 constant SCHEMA_HASH = 0x%x;", hash))
+	b.P("// Type URL of the message, as in google.protobuf.Any")
+	b.P(fmt.Sprintf("string constant TYPE_URL = \"%s\";", g.toTypeURL(structName)))
+	b.P()
+
+	return nil
+}
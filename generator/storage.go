@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// generateStorageFunctions generates the store and load functions of a
+// message's codec library, which copy a message between memory and storage.
+// Solidity can't copy memory arrays of structs to storage, so embedded messages
+// and repeated message fields are copied member by member.
+func (g *Generator) generateStorageFunctions(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	b.P("// Copy src into dst, replacing its previous value")
+	b.P(fmt.Sprintf("function store(%s storage dst, %s memory src) internal {", structName, structName))
+	b.Indent()
+	for _, field := range fields {
+		fieldName := field.GetName()
+
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			b.P(fmt.Sprintf("dst.%s = src.%s;", fieldName, fieldName))
+			continue
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		if !isFieldRepeated(field) {
+			b.P(fmt.Sprintf("%sCodec.store(dst.%s, src.%s);", fieldTypeName, fieldName, fieldName))
+			continue
+		}
+
+		b.P("{")
+		b.Indent()
+		b.P("// Remove stale elements, then overwrite or append the others")
+		b.P(fmt.Sprintf("while (dst.%s.length > src.%s.length) {", fieldName, fieldName))
+		b.Indent()
+		b.P(fmt.Sprintf("dst.%s.pop();", fieldName))
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("for (uint256 i = 0; i < src.%s.length; i++) {", fieldName))
+		b.Indent()
+		b.P(fmt.Sprintf("if (i == dst.%s.length) {", fieldName))
+		b.Indent()
+		b.P(fmt.Sprintf("dst.%s.push();", fieldName))
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("%sCodec.store(dst.%s[i], src.%s[i]);", fieldTypeName, fieldName, fieldName))
+		b.Unindent()
+		b.P("}")
+		b.Unindent()
+		b.P("}")
+	}
+	if g.isWrapper(structName) {
+		b.P("dst.present = src.present;")
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Copy src into a new memory instance")
+	b.P(fmt.Sprintf("function load(%s storage src) internal view returns (%s memory) {", structName, structName))
+	b.Indent()
+	b.P(fmt.Sprintf("%s memory dst;", structName))
+	for _, field := range fields {
+		fieldName := field.GetName()
+
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			b.P(fmt.Sprintf("dst.%s = src.%s;", fieldName, fieldName))
+			continue
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+
+		if !isFieldRepeated(field) {
+			b.P(fmt.Sprintf("dst.%s = %sCodec.load(src.%s);", fieldName, fieldTypeName, fieldName))
+			continue
+		}
+
+		b.P(fmt.Sprintf("dst.%s = new %s[](src.%s.length);", fieldName, fieldTypeName, fieldName))
+		b.P(fmt.Sprintf("for (uint256 i = 0; i < src.%s.length; i++) {", fieldName))
+		b.Indent()
+		b.P(fmt.Sprintf("dst.%s[i] = %sCodec.load(src.%s[i]);", fieldName, fieldTypeName, fieldName))
+		b.Unindent()
+		b.P("}")
+	}
+	if g.isWrapper(structName) {
+		b.P("dst.present = src.present;")
+	}
+	b.P()
+	b.P("return dst;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package generator
+
+import "fmt"
+
+// generateStreamDecoder generates the functions of a message's codec library
+// that decode a stream of messages, each prefixed by its length as a varint.
+func generateStreamDecoder(structName string, input decoderInput, b *WriteableBuffer) {
+	b.P("// Decode the length-prefixed message at pos of a stream, returns the position of the next one")
+	b.P(fmt.Sprintf("function decode_delimited%s(uint64 pos, %s buf) internal pure returns (bool, uint64, %s memory) {", input.suffix, input.bufType, structName))
+	b.Indent()
+	b.P(fmt.Sprintf("(bool success, uint64 start, uint64 len) = %s.decode_length_delimited(pos, buf);", input.lib))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P(fmt.Sprintf("%s memory instance;", structName))
+	b.P("return (false, start, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P(fmt.Sprintf("return decode%s(start, buf, len);", input.suffix))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Decode a stream of length-prefixed messages, which must take all of buf")
+	b.P(fmt.Sprintf("function decode_delimited_stream%s(%s buf) internal pure returns (bool, %s[] memory) {", input.suffix, input.bufType, structName))
+	b.Indent()
+	b.P("// Count the messages, checking their lengths")
+	b.P("uint64 cnt = 0;")
+	b.P("uint64 pos = 0;")
+	b.P("while (pos < buf.length) {")
+	b.Indent()
+	b.P(fmt.Sprintf("(bool success, uint64 start, uint64 len) = %s.decode_length_delimited(pos, buf);", input.lib))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P(fmt.Sprintf("return (false, new %s[](0));", structName))
+	b.Unindent()
+	b.P("}")
+	b.P("pos = start + len;")
+	b.P("cnt++;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("// Now actually decode the messages")
+	b.P(fmt.Sprintf("%s[] memory instances = new %s[](cnt);", structName, structName))
+	b.P("pos = 0;")
+	b.P("for (uint64 i = 0; i < cnt; i++) {")
+	b.Indent()
+	b.P("bool success;")
+	b.P(fmt.Sprintf("(success, pos, instances[i]) = decode_delimited%s(pos, buf);", input.suffix))
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return (false, instances);")
+	b.Unindent()
+	b.P("}")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return (true, instances);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateStreamEncoder generates the function of a message's codec library
+// that encodes messages as a stream, each prefixed by its length as a varint.
+func generateStreamEncoder(structName string, b *WriteableBuffer) {
+	b.P("// Encode instances as a stream of length-prefixed messages")
+	b.P(fmt.Sprintf("function encode_delimited_stream(%s[] memory instances) internal pure returns (bytes memory) {", structName))
+	b.Indent()
+	b.P("// Size each message once, allocate exactly enough bytes, then encode in place")
+	b.P("uint64[][] memory sizes = new uint64[][](instances.length);")
+	b.P("uint64 len = 0;")
+	b.P("for (uint256 i = 0; i < instances.length; i++) {")
+	b.Indent()
+	b.P("sizes[i] = cached_sizes(instances[i]);")
+	b.P(fmt.Sprintf("len += %s.size_varint(sizes[i][0]) + sizes[i][0];", supportLibName))
+	b.Unindent()
+	b.P("}")
+	b.P("bytes memory buf = new bytes(len);")
+	b.P()
+	b.P("uint64 pos = 0;")
+	b.P("for (uint256 i = 0; i < instances.length; i++) {")
+	b.Indent()
+	b.P(fmt.Sprintf("pos = %s.write_varint(pos, buf, sizes[i][0]);", supportLibName))
+	b.P("pos = encode_at(pos, buf, instances[i], sizes[i]);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return buf;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
@@ -0,0 +1,513 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// generateSupportFile generates a Solidity file holding a support library that
+// is shared by all generated files, rather than duplicated in each of them. The
+// library is declared for the Solidity version specifier libVersion, and may
+// use other support libraries, given by name in imports.
+func (g *Generator) generateSupportFile(libName string, libVersion string, libSource string, imports ...string) *pluginpb.CodeGeneratorResponse_File {
+	b := &WriteableBuffer{}
+
+	// Generate heading
+	b.P(fmt.Sprintf("// File automatically generated by protoc-gen-sol %s", g.versionString))
+	b.P(fmt.Sprintf("// SPDX-License-Identifier: %s", g.licenseString))
+	b.P("pragma solidity " + libVersion + ";")
+	b.P(SolidityABIString)
+	b.P()
+
+	// Generate imports
+	b.P("import \"@lazyledger/protobuf3-solidity-lib/contracts/ProtobufLib.sol\";")
+	for _, imported := range imports {
+		b.P(fmt.Sprintf("import \"./%s.sol\";", imported))
+	}
+	b.P()
+
+	responseFile := &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(libName + ".sol"),
+		Content: proto.String(b.String() + libSource),
+	}
+
+	return responseFile
+}
+
+// isSupportLibUsed returns true if generated files use the support library.
+func (g *Generator) isSupportLibUsed() bool {
+	if g.eip712 || g.registry {
+		return true
+	}
+	// Yul codecs are self-contained, but delimited streams are encoded with
+	// the support library
+	if g.backendFlag == backendFlagYul {
+		return g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder
+	}
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		return true
+	}
+	if g.backendFlag == backendFlagTable {
+		return true
+	}
+	if g.generateFlag == generateFlagDecoder && g.inputFlag != inputFlagCalldata {
+		return true
+	}
+	return g.accessors || g.verifiers || g.iterators
+}
+
+// supportLibVersionString returns the Solidity version specifier of the support
+// library, which doesn't depend on the input of decoders.
+func (g *Generator) supportLibVersionString() string {
+	if g.evmFlag == evmFlagCancun {
+		return SolidityCancunVersionString
+	}
+	return SolidityVersionString
+}
+
+// supportLibSource returns the source of the support library for the target
+// EVM version. The library has the same functions whatever the other
+// parameters, so that codecs generated with different parameters can share it:
+// unused internal functions aren't compiled into contracts.
+func (g *Generator) supportLibSource() string {
+	source := supportLibBaseSource + supportLibMerkleSource + supportLibEIP712Source + supportLibRegistrySource
+	if g.evmFlag == evmFlagCancun {
+		return source + supportLibCancunCopySource
+	}
+	return source + supportLibCopySource
+}
+
+const supportLibName = "ProtobufSupportLib"
+
+// supportLibBaseSource holds helpers used by generated code that are not
+// provided by ProtobufLib, except for the copy function.
+const supportLibBaseSource = `library ProtobufSupportLib {
+    /// @notice Skip over a field value, given its wire type.
+    function skip_field(
+        uint64 p,
+        bytes memory buf,
+        ProtobufLib.WireType wire_type
+    ) internal pure returns (bool, uint64) {
+        bool success;
+        uint64 pos = p;
+
+        if (wire_type == ProtobufLib.WireType.Varint) {
+            (success, pos, ) = ProtobufLib.decode_varint(pos, buf);
+            return (success, pos);
+        }
+
+        uint64 size;
+        if (wire_type == ProtobufLib.WireType.Bits64) {
+            size = 8;
+        } else if (wire_type == ProtobufLib.WireType.Bits32) {
+            size = 4;
+        } else if (wire_type == ProtobufLib.WireType.LengthDelimited) {
+            (success, pos, size) = ProtobufLib.decode_length_delimited(pos, buf);
+            if (!success) {
+                return (false, pos);
+            }
+        } else {
+            return (false, pos);
+        }
+
+        // Check that index is within bounds
+        if (pos + size < pos || pos + size > buf.length) {
+            return (false, pos);
+        }
+
+        return (true, pos + size);
+    }
+
+    /// @notice Number of bytes needed to encode a varint.
+    function size_varint(uint64 v) internal pure returns (uint64) {
+        uint64 size = 1;
+        while (v >= 0x80) {
+            v >>= 7;
+            size++;
+        }
+
+        return size;
+    }
+
+    /// @notice Number of bytes needed to encode an int32 or int64.
+    function size_int64(int64 v) internal pure returns (uint64) {
+        // Negative values are sign-extended to 64 bits, so always use 10 bytes
+        if (v < 0) {
+            return 10;
+        }
+
+        return size_varint(uint64(v));
+    }
+
+    /// @notice Number of bytes needed to encode a sint32 or sint64.
+    function size_sint64(int64 v) internal pure returns (uint64) {
+        return size_varint(zigzag(v));
+    }
+
+    function zigzag(int64 v) internal pure returns (uint64) {
+        return uint64((v << 1) ^ (v >> 63));
+    }
+
+    /// @notice Encode a varint into buf at p, which must have enough bytes.
+    /// @return The position after the encoded varint.
+    function write_varint(
+        uint64 p,
+        bytes memory buf,
+        uint64 v
+    ) internal pure returns (uint64) {
+        uint64 pos = p;
+
+        // Groups of 7 bits are ordered least significant first, with the
+        // highest bit set if there are more bytes to come
+        while (v >= 0x80) {
+            buf[pos] = bytes1(uint8(v & 0x7F) | 0x80);
+            v >>= 7;
+            pos++;
+        }
+        buf[pos] = bytes1(uint8(v));
+
+        return pos + 1;
+    }
+
+    function write_int64(
+        uint64 p,
+        bytes memory buf,
+        int64 v
+    ) internal pure returns (uint64) {
+        // Negative values are sign-extended to 64 bits
+        return write_varint(p, buf, uint64(v));
+    }
+
+    function write_sint64(
+        uint64 p,
+        bytes memory buf,
+        int64 v
+    ) internal pure returns (uint64) {
+        return write_varint(p, buf, zigzag(v));
+    }
+
+    function write_bool(
+        uint64 p,
+        bytes memory buf,
+        bool v
+    ) internal pure returns (uint64) {
+        return write_varint(p, buf, v ? 1 : 0);
+    }
+
+    function write_bits32(
+        uint64 p,
+        bytes memory buf,
+        uint32 v
+    ) internal pure returns (uint64) {
+        // Little-endian
+        for (uint64 i = 0; i < 4; i++) {
+            buf[p + i] = bytes1(uint8(v >> (i * 8)));
+        }
+
+        return p + 4;
+    }
+
+    function write_bits64(
+        uint64 p,
+        bytes memory buf,
+        uint64 v
+    ) internal pure returns (uint64) {
+        // Little-endian
+        for (uint64 i = 0; i < 8; i++) {
+            buf[p + i] = bytes1(uint8(v >> (i * 8)));
+        }
+
+        return p + 8;
+    }
+
+    /// @notice Copy data into buf at p, which must have enough bytes.
+    /// @return The position after the copied bytes.
+    function write_bytes(
+        uint64 p,
+        bytes memory buf,
+        bytes memory data
+    ) internal pure returns (uint64) {
+        uint256 src;
+        uint256 dst;
+        /// @solidity memory-safe-assembly
+        assembly {
+            src := add(data, 32)
+            dst := add(add(buf, 32), p)
+        }
+        copy(src, dst, data.length);
+
+        return p + uint64(data.length);
+    }
+
+    /// @notice Copy len bytes of buf starting at pos into a new bytes array.
+    function slice(
+        bytes memory buf,
+        uint64 pos,
+        uint64 len
+    ) internal pure returns (bytes memory) {
+        bytes memory out = new bytes(len);
+
+        uint256 src;
+        uint256 dst;
+        /// @solidity memory-safe-assembly
+        assembly {
+            src := add(add(buf, 32), pos)
+            dst := add(out, 32)
+        }
+        copy(src, dst, len);
+
+        return out;
+    }
+
+    /// @notice Range of bytes of a decoded buffer, used for string and bytes
+    /// fields decoded without copying.
+    struct View {
+        bytes buf;
+        uint64 offset;
+        uint64 length;
+    }
+
+    /// @notice Keccak-256 hash of the bytes of a view.
+    function hash(View memory v) internal pure returns (bytes32 h) {
+        bytes memory buf = v.buf;
+        uint64 offset = v.offset;
+        uint64 length = v.length;
+        /// @solidity memory-safe-assembly
+        assembly {
+            h := keccak256(add(add(buf, 32), offset), length)
+        }
+    }
+
+    /// @notice Whether the bytes of a view are equal to data.
+    function equals(View memory v, bytes memory data) internal pure returns (bool) {
+        return v.length == data.length && hash(v) == keccak256(data);
+    }
+
+    /// @notice Copy the bytes of a view into a new bytes array.
+    function to_bytes(View memory v) internal pure returns (bytes memory) {
+        return slice(v.buf, v.offset, v.length);
+    }
+
+    /// @notice Copy the bytes of a view into a new string.
+    function to_string(View memory v) internal pure returns (string memory) {
+        return string(slice(v.buf, v.offset, v.length));
+    }
+
+    /// @notice Copy the bytes of a view into buf at p, which must have enough
+    /// bytes.
+    /// @return The position after the copied bytes.
+    function write_view(
+        uint64 p,
+        bytes memory buf,
+        View memory v
+    ) internal pure returns (uint64) {
+        bytes memory data = v.buf;
+        uint64 offset = v.offset;
+        uint256 src;
+        uint256 dst;
+        /// @solidity memory-safe-assembly
+        assembly {
+            src := add(add(data, 32), offset)
+            dst := add(add(buf, 32), p)
+        }
+        copy(src, dst, v.length);
+
+        return p + v.length;
+    }
+`
+
+// supportLibMerkleSource holds the Merkle tree functions of the support
+// library, which follow CometBFT's crypto/merkle package.
+const supportLibMerkleSource = `
+    /// @notice Hash of a Merkle leaf, with the RFC 6962 leaf prefix.
+    function leaf_hash(bytes memory leaf) internal pure returns (bytes32) {
+        return sha256(abi.encodePacked(bytes1(0x00), leaf));
+    }
+
+    /// @notice Hash of a Merkle inner node, with the RFC 6962 inner prefix.
+    function inner_hash(bytes32 left, bytes32 right) internal pure returns (bytes32) {
+        return sha256(abi.encodePacked(bytes1(0x01), left, right));
+    }
+
+    /// @notice Largest power of two less than n, which must be at least 2.
+    function split_point(uint256 n) internal pure returns (uint256) {
+        uint256 k = 1;
+        while (k * 2 < n) {
+            k *= 2;
+        }
+
+        return k;
+    }
+
+    /// @notice Merkle root of leaf hashes, the hash of no bytes if there are none.
+    function merkle_root(bytes32[] memory hashes) internal pure returns (bytes32) {
+        if (hashes.length == 0) {
+            return sha256(new bytes(0));
+        }
+
+        return merkle_root(hashes, 0, hashes.length);
+    }
+
+    /// @notice Merkle root of the leaf hashes from start to end, which must not be empty.
+    function merkle_root(
+        bytes32[] memory hashes,
+        uint256 start,
+        uint256 end
+    ) internal pure returns (bytes32) {
+        if (end - start == 1) {
+            return hashes[start];
+        }
+
+        uint256 k = split_point(end - start);
+        return inner_hash(merkle_root(hashes, start, start + k), merkle_root(hashes, start + k, end));
+    }
+
+    /// @notice Verify that leaf is the hash of leaf index of total leaves
+    /// in the Merkle tree of the given root, with aunts from the bottom up.
+    function verify_merkle_proof(
+        bytes32 root,
+        uint256 index,
+        uint256 total,
+        bytes32 leaf,
+        bytes32[] memory aunts
+    ) internal pure returns (bool) {
+        (bool success, bytes32 h) = root_from_aunts(index, total, leaf, aunts, aunts.length);
+
+        return success && h == root;
+    }
+
+    /// @notice Merkle root computed from a leaf hash and the first len aunts.
+    function root_from_aunts(
+        uint256 index,
+        uint256 total,
+        bytes32 leaf,
+        bytes32[] memory aunts,
+        uint256 len
+    ) internal pure returns (bool, bytes32) {
+        if (index >= total) {
+            return (false, 0);
+        }
+        if (total == 1) {
+            return (len == 0, leaf);
+        }
+        if (len == 0) {
+            return (false, 0);
+        }
+
+        // The last aunt is the sibling of the subtree holding the leaf
+        uint256 k = split_point(total);
+        bool success;
+        bytes32 h;
+        if (index < k) {
+            (success, h) = root_from_aunts(index, k, leaf, aunts, len - 1);
+            return (success, inner_hash(h, aunts[len - 1]));
+        }
+        (success, h) = root_from_aunts(index - k, total - k, leaf, aunts, len - 1);
+        return (success, inner_hash(aunts[len - 1], h));
+    }
+`
+
+// supportLibEIP712Source holds the EIP-712 functions of the support library.
+const supportLibEIP712Source = `
+    bytes32 constant EIP712_DOMAIN_TYPEHASH =
+        keccak256("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)");
+
+    /// @notice EIP-712 domain separator.
+    function domain_separator(
+        string memory name,
+        string memory version,
+        uint256 chain_id,
+        address verifying_contract
+    ) internal pure returns (bytes32) {
+        return
+            keccak256(
+                abi.encode(
+                    EIP712_DOMAIN_TYPEHASH,
+                    keccak256(bytes(name)),
+                    keccak256(bytes(version)),
+                    chain_id,
+                    verifying_contract
+                )
+            );
+    }
+
+    /// @notice EIP-712 digest of a struct hash in a domain, which is signed.
+    function typed_data_hash(bytes32 separator, bytes32 struct_hash) internal pure returns (bytes32) {
+        return keccak256(abi.encodePacked("\x19\x01", separator, struct_hash));
+    }
+`
+
+// supportLibRegistrySource holds the type URL functions of the support library.
+const supportLibRegistrySource = `
+    /// @notice Keccak-256 hash of the type name of a type URL, the part after
+    /// its last slash, which must be present.
+    function type_name_hash(string memory type_url) internal pure returns (bool, bytes32) {
+        bytes memory url = bytes(type_url);
+
+        uint256 i = url.length;
+        while (i > 0 && url[i - 1] != "/") {
+            i--;
+        }
+        if (i == 0) {
+            return (false, 0);
+        }
+
+        bytes32 h;
+        /// @solidity memory-safe-assembly
+        assembly {
+            h := keccak256(add(add(url, 32), i), sub(mload(url), i))
+        }
+
+        return (true, h);
+    }
+`
+
+// supportLibCopySource holds the copy function of the support library, which
+// copies 32-byte words followed by a masked partial word.
+const supportLibCopySource = `
+    /// @notice Copy len bytes of memory from src to dst.
+    function copy(
+        uint256 src,
+        uint256 dst,
+        uint256 len
+    ) internal pure {
+        /// @solidity memory-safe-assembly
+        assembly {
+            for {
+
+            } gt(len, 31) {
+                len := sub(len, 32)
+            } {
+                mstore(dst, mload(src))
+                src := add(src, 32)
+                dst := add(dst, 32)
+            }
+
+            // Copy the remaining bytes, leaving the bytes after them in dst as is
+            if gt(len, 0) {
+                let mask := sub(exp(256, sub(32, len)), 1)
+                mstore(dst, or(and(mload(src), not(mask)), and(mload(dst), mask)))
+            }
+        }
+    }
+}
+`
+
+// supportLibCancunCopySource holds the copy function of the support library
+// for EVM versions that have the MCOPY instruction.
+const supportLibCancunCopySource = `
+    /// @notice Copy len bytes of memory from src to dst.
+    function copy(
+        uint256 src,
+        uint256 dst,
+        uint256 len
+    ) internal pure {
+        /// @solidity memory-safe-assembly
+        assembly {
+            mcopy(dst, src, len)
+        }
+    }
+}
+`
@@ -0,0 +1,1154 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Flags of a field table entry
+const (
+	tableFlagRepeated = 1
+	tableFlagPacked   = 2
+)
+
+// Size in bytes of a field table entry
+const tableEntrySize = 8
+
+// checkBackend checks that the selected backend supports the other parameters.
+func (g *Generator) checkBackend() error {
+	if g.backendFlag == backendFlagSolidity {
+		return nil
+	}
+
+	backend := fromBackendFlag(g.backendFlag)
+	if g.inputFlag != inputFlagMemory {
+		return fmt.Errorf("backend %s only supports input %s", backend, fromInputFlag(inputFlagMemory))
+	}
+	if g.accessors {
+		return fmt.Errorf("backend %s does not support accessors", backend)
+	}
+	if g.verifiers {
+		return fmt.Errorf("backend %s does not support verifiers", backend)
+	}
+	if g.iterators {
+		return fmt.Errorf("backend %s does not support iterators", backend)
+	}
+	if g.partial {
+		return fmt.Errorf("backend %s does not support partial decoders", backend)
+	}
+	if g.optimizeFlag != optimizeFlagGas {
+		return fmt.Errorf("backend %s does not support optimize %s", backend, fromOptimizeFlag(g.optimizeFlag))
+	}
+	if g.limits != (decodeLimits{}) || len(g.messageLimits) > 0 {
+		return fmt.Errorf("backend %s does not support decoding limits", backend)
+	}
+
+	return nil
+}
+
+// tableMessages returns the messages whose tables make up the table of a
+// message: the message itself first, then the messages it embeds, directly or
+// not, in order of first appearance. Tables refer to each other by offset, so
+// recursive messages share their table.
+func (g *Generator) tableMessages(structName string, messages []string) ([]string, error) {
+	for _, message := range messages {
+		if message == structName {
+			return messages, nil
+		}
+	}
+
+	descriptor, ok := g.messages[structName]
+	if !ok {
+		return nil, errors.New("unknown message: " + structName)
+	}
+
+	messages = append(messages, structName)
+	for _, field := range descriptor.GetField() {
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return nil, err
+		}
+
+		messages, err = g.tableMessages(fieldTypeName, messages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return messages, nil
+}
+
+// buildTable returns the table of a message, which is interpreted by the table
+// library, and the offset of each message's table within it.
+//
+// The table of each message is a big-endian uint16 field count, followed by one
+// 8-byte entry per field, in order of field number:
+//   - field number (uint16)
+//   - wire type (uint8)
+//   - field type, as numbered in descriptor.proto (uint8)
+//   - flags (uint8)
+//   - index of the field's struct member (uint8)
+//   - offset of the embedded message's table, or maximum value of the enum (uint16)
+func (g *Generator) buildTable(structName string) ([]byte, map[string]int, error) {
+	messages, err := g.tableMessages(structName, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offsets := make(map[string]int)
+	offset := 0
+	for _, message := range messages {
+		offsets[message] = offset
+		offset += 2 + len(g.messages[message].GetField())*tableEntrySize
+	}
+	if offset > 0xFFFF {
+		return nil, nil, errors.New("table too large: " + structName)
+	}
+
+	table := []byte{}
+	for _, message := range messages {
+		fields := g.messages[message].GetField()
+		if len(fields) > 0xFF {
+			return nil, nil, errors.New("too many fields for table: " + message)
+		}
+		table = appendUint16(table, len(fields))
+
+		members := g.memberIndexes(fields)
+		for i, field := range fields {
+			wireType, err := toWireType(field)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			flags := 0
+			if isFieldRepeated(field) {
+				flags |= tableFlagRepeated
+			}
+			if isFieldPacked(field) {
+				flags |= tableFlagPacked
+			}
+
+			ref := 0
+			switch field.GetType() {
+			case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+				descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+				fieldTypeName, err := toSolMessageOrEnumName(field)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+					ref = offsets[fieldTypeName]
+				} else {
+					ref = g.enumMaxes[fieldTypeName]
+				}
+			}
+			if ref > 0xFFFF {
+				return nil, nil, errors.New("table reference too large: " + message + "." + field.GetName())
+			}
+
+			table = appendUint16(table, int(field.GetNumber()))
+			table = append(table, byte(wireType), byte(field.GetType()), byte(flags), byte(members[i]))
+			table = appendUint16(table, ref)
+		}
+	}
+
+	return table, offsets, nil
+}
+
+// appendUint16 appends v to b as a big-endian uint16.
+func appendUint16(b []byte, v int) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// generateTableCodec generates the functions of a message's codec library,
+// which call the table library with the message's table.
+func (g *Generator) generateTableCodec(structName string, b *WriteableBuffer) error {
+	table, offsets, err := g.buildTable(structName)
+	if err != nil {
+		return err
+	}
+
+	messages, err := g.tableMessages(structName, nil)
+	if err != nil {
+		return err
+	}
+
+	b.P("// Table of the message, followed by the tables of the messages it embeds")
+	for _, message := range messages {
+		b.P(fmt.Sprintf("// - %s at offset %d", message, offsets[message]))
+	}
+	b.P(fmt.Sprintf("bytes constant TABLE = hex\"%x\";", table))
+	b.P()
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
+		b.P(fmt.Sprintf("function decode(uint64 initial_pos, bytes memory buf, uint64 len) internal pure returns (bool, uint64, %s memory) {", structName))
+		b.Indent()
+		b.P(fmt.Sprintf("(bool success, uint64 pos, uint256 ptr) = %s.decode(initial_pos, buf, len, TABLE);", tableLibName))
+		b.P()
+		b.P(fmt.Sprintf("%s memory instance;", structName))
+		b.P("/// @solidity memory-safe-assembly")
+		b.P("assembly {")
+		b.Indent()
+		b.P("instance := ptr")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return (success, pos, instance);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		b.P(fmt.Sprintf("function encode(%s memory instance) internal pure returns (bytes memory) {", structName))
+		b.Indent()
+		b.P(fmt.Sprintf("return %s.encode(to_ptr(instance), TABLE);", tableLibName))
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Number of bytes needed to encode instance")
+		b.P(fmt.Sprintf("function encoded_size(%s memory instance) internal pure returns (uint64) {", structName))
+		b.Indent()
+		b.P(fmt.Sprintf("return %s.encoded_size(to_ptr(instance), TABLE, 0);", tableLibName))
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Encode instance into buf at pos, which must have enough bytes, returns the new pos")
+		b.P(fmt.Sprintf("function encode_at(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (uint64) {", structName))
+		b.Indent()
+		b.P(fmt.Sprintf("return %s.encode_at(pos, buf, to_ptr(instance), TABLE, 0);", tableLibName))
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		generateUncachedSizes(structName, b)
+
+		b.P(fmt.Sprintf("function to_ptr(%s memory instance) internal pure returns (uint256 ptr) {", structName))
+		b.Indent()
+		b.P("/// @solidity memory-safe-assembly")
+		b.P("assembly {")
+		b.Indent()
+		b.P("ptr := instance")
+		b.Unindent()
+		b.P("}")
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+
+	return nil
+}
+
+const tableLibName = "ProtobufTableLib"
+
+// tableLibSource holds the interpreter that decodes and encodes any message,
+// given its table. Messages are read from and written to memory laid out as
+// Solidity structs, with one 32-byte word per member.
+const tableLibSource = `library ProtobufTableLib {
+    // Flags of a field table entry
+    uint8 constant FLAG_REPEATED = 1;
+    uint8 constant FLAG_PACKED = 2;
+
+    // Field types, as numbered in descriptor.proto
+    uint8 constant TYPE_INT64 = 3;
+    uint8 constant TYPE_UINT64 = 4;
+    uint8 constant TYPE_INT32 = 5;
+    uint8 constant TYPE_FIXED64 = 6;
+    uint8 constant TYPE_FIXED32 = 7;
+    uint8 constant TYPE_BOOL = 8;
+    uint8 constant TYPE_STRING = 9;
+    uint8 constant TYPE_MESSAGE = 11;
+    uint8 constant TYPE_BYTES = 12;
+    uint8 constant TYPE_UINT32 = 13;
+    uint8 constant TYPE_ENUM = 14;
+    uint8 constant TYPE_SFIXED32 = 15;
+    uint8 constant TYPE_SFIXED64 = 16;
+    uint8 constant TYPE_SINT32 = 17;
+    uint8 constant TYPE_SINT64 = 18;
+
+    // Zero slot, pointed to by empty dynamic members
+    uint256 constant ZERO_SLOT = 0x60;
+
+    // Entry of a message's field table
+    struct Field {
+        uint64 number;
+        uint8 wire_type;
+        uint8 field_type;
+        uint8 flags;
+        // Index of the field's struct member
+        uint8 member;
+        // Offset of the embedded message's table, or maximum value of the enum
+        uint64 ref;
+    }
+
+    ////////////////////////////////////
+    // Tables
+    ////////////////////////////////////
+
+    function read_uint16(bytes memory table, uint256 offset) internal pure returns (uint64) {
+        return (uint64(uint8(table[offset])) << 8) | uint64(uint8(table[offset + 1]));
+    }
+
+    /// @notice Number of fields of the message whose table is at offset.
+    function field_count(bytes memory table, uint256 offset) internal pure returns (uint64) {
+        return read_uint16(table, offset);
+    }
+
+    /// @notice Field at index of the message whose table is at offset.
+    function read_field(
+        bytes memory table,
+        uint256 offset,
+        uint64 index
+    ) internal pure returns (Field memory) {
+        uint256 entry = offset + 2 + index * 8;
+
+        Field memory field;
+        field.number = read_uint16(table, entry);
+        field.wire_type = uint8(table[entry + 2]);
+        field.field_type = uint8(table[entry + 3]);
+        field.flags = uint8(table[entry + 4]);
+        field.member = uint8(table[entry + 5]);
+        field.ref = read_uint16(table, entry + 6);
+
+        return field;
+    }
+
+    function key(Field memory field) internal pure returns (uint64) {
+        return (field.number << 3) | uint64(field.wire_type);
+    }
+
+    ////////////////////////////////////
+    // Memory
+    ////////////////////////////////////
+
+    /// @notice Allocate size bytes of zeroed memory, size being a multiple of 32.
+    function allocate(uint256 size) internal pure returns (uint256 ptr) {
+        /// @solidity memory-safe-assembly
+        assembly {
+            ptr := mload(0x40)
+            mstore(0x40, add(ptr, size))
+            for {
+                let i := 0
+            } lt(i, size) {
+                i := add(i, 32)
+            } {
+                mstore(add(ptr, i), 0)
+            }
+        }
+    }
+
+    function load_word(uint256 ptr, uint256 index) internal pure returns (uint256 v) {
+        /// @solidity memory-safe-assembly
+        assembly {
+            v := mload(add(ptr, mul(index, 32)))
+        }
+    }
+
+    function store_word(
+        uint256 ptr,
+        uint256 index,
+        uint256 v
+    ) internal pure {
+        /// @solidity memory-safe-assembly
+        assembly {
+            mstore(add(ptr, mul(index, 32)), v)
+        }
+    }
+
+    /// @notice Allocate a message with default values.
+    function new_message(bytes memory table, uint256 offset) internal pure returns (uint256) {
+        uint64 count = field_count(table, offset);
+        // Messages without fields have a placeholder member
+        uint256 ptr = allocate(count == 0 ? 32 : count * 32);
+
+        for (uint64 i = 0; i < count; i++) {
+            Field memory field = read_field(table, offset, i);
+
+            if ((field.flags & FLAG_REPEATED) != 0 || field.field_type == TYPE_STRING || field.field_type == TYPE_BYTES) {
+                store_word(ptr, field.member, ZERO_SLOT);
+            } else if (field.field_type == TYPE_MESSAGE) {
+                store_word(ptr, field.member, new_message(table, field.ref));
+            }
+        }
+
+        return ptr;
+    }
+
+    ////////////////////////////////////
+    // Decoding
+    ////////////////////////////////////
+
+    /// @notice Decode a message, given its table.
+    /// @return Whether decoding succeeded, the position after the message, and a pointer to the message.
+    function decode(
+        uint64 initial_pos,
+        bytes memory buf,
+        uint64 len,
+        bytes memory table
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        return decode_message(initial_pos, buf, len, table, 0);
+    }
+
+    function decode_message(
+        uint64 initial_pos,
+        bytes memory buf,
+        uint64 len,
+        bytes memory table,
+        uint256 offset
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        uint256 ptr = new_message(table, offset);
+        uint64 pos = initial_pos;
+
+        // Sanity checks
+        if (pos + len < pos) {
+            return (false, pos, ptr);
+        }
+
+        uint64 end = initial_pos + len;
+        uint64 previous_field_number = 0;
+        while (pos < end) {
+            bool success;
+            (success, pos, previous_field_number) = decode_next_field(pos, buf, end, table, offset, ptr, previous_field_number);
+            if (!success) {
+                return (false, pos, ptr);
+            }
+        }
+
+        // Decoding must have consumed len bytes
+        if (pos != end) {
+            return (false, pos, ptr);
+        }
+
+        return (true, pos, ptr);
+    }
+
+    /// @notice Decode the next field of a message, which ends at end.
+    /// @return Whether decoding succeeded, the new position, and the field number.
+    function decode_next_field(
+        uint64 pos,
+        bytes memory buf,
+        uint64 end,
+        bytes memory table,
+        uint256 offset,
+        uint256 ptr,
+        uint64 previous_field_number
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint64
+        )
+    {
+        bool success;
+        Field memory field;
+        (success, pos, field) = decode_field_key(pos, buf, table, offset, previous_field_number);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        (success, pos) = decode_field(pos, buf, end, table, field, ptr);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, field.number);
+    }
+
+    /// @notice Decode a key, checking its field number and wire type.
+    function decode_field_key(
+        uint64 pos,
+        bytes memory buf,
+        bytes memory table,
+        uint256 offset,
+        uint64 previous_field_number
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            Field memory
+        )
+    {
+        Field memory field;
+
+        // Decode the key (field number and wire type)
+        bool success;
+        uint64 field_number;
+        ProtobufLib.WireType wire_type;
+        (success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);
+        if (!success) {
+            return (false, pos, field);
+        }
+
+        // Check that the field number is within bounds
+        if (field_number > field_count(table, offset)) {
+            return (false, pos, field);
+        }
+
+        // Check that the field number of monotonically increasing
+        if (field_number <= previous_field_number) {
+            return (false, pos, field);
+        }
+
+        // Check that the wire type is correct
+        field = read_field(table, offset, field_number - 1);
+        if (uint8(wire_type) != field.wire_type) {
+            return (false, pos, field);
+        }
+
+        return (true, pos, field);
+    }
+
+    /// @notice Decode the value of a field into the message at ptr.
+    function decode_field(
+        uint64 pos,
+        bytes memory buf,
+        uint64 end,
+        bytes memory table,
+        Field memory field,
+        uint256 ptr
+    ) internal pure returns (bool, uint64) {
+        bool success;
+        uint256 value;
+
+        if ((field.flags & FLAG_PACKED) != 0) {
+            (success, pos, value) = decode_packed(pos, buf, field);
+        } else if ((field.flags & FLAG_REPEATED) != 0) {
+            (success, pos, value) = decode_repeated_message(pos, buf, end, table, field);
+        } else if (field.field_type == TYPE_MESSAGE) {
+            (success, pos, value) = decode_nested(pos, buf, table, field.ref);
+        } else if (field.field_type == TYPE_STRING || field.field_type == TYPE_BYTES) {
+            (success, pos, value) = decode_bytes(pos, buf);
+        } else {
+            (success, pos, value) = decode_scalar(pos, buf, field);
+
+            // Default value must be omitted
+            if (value == 0) {
+                success = false;
+            }
+        }
+        if (!success) {
+            return (false, pos);
+        }
+
+        store_word(ptr, field.member, value);
+
+        return (true, pos);
+    }
+
+    /// @notice Decode a numeric, bool or enum value, as a memory word.
+    function decode_scalar(
+        uint64 pos,
+        bytes memory buf,
+        Field memory field
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        bool success;
+        uint8 field_type = field.field_type;
+
+        if (field_type == TYPE_INT32) {
+            int32 v;
+            (success, pos, v) = ProtobufLib.decode_int32(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_INT64) {
+            int64 v;
+            (success, pos, v) = ProtobufLib.decode_int64(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_UINT32) {
+            uint32 v;
+            (success, pos, v) = ProtobufLib.decode_uint32(pos, buf);
+            return (success, pos, uint256(v));
+        }
+        if (field_type == TYPE_UINT64) {
+            uint64 v;
+            (success, pos, v) = ProtobufLib.decode_uint64(pos, buf);
+            return (success, pos, uint256(v));
+        }
+        if (field_type == TYPE_SINT32) {
+            int32 v;
+            (success, pos, v) = ProtobufLib.decode_sint32(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_SINT64) {
+            int64 v;
+            (success, pos, v) = ProtobufLib.decode_sint64(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_FIXED32) {
+            uint32 v;
+            (success, pos, v) = ProtobufLib.decode_fixed32(pos, buf);
+            return (success, pos, uint256(v));
+        }
+        if (field_type == TYPE_FIXED64) {
+            uint64 v;
+            (success, pos, v) = ProtobufLib.decode_fixed64(pos, buf);
+            return (success, pos, uint256(v));
+        }
+        if (field_type == TYPE_SFIXED32) {
+            int32 v;
+            (success, pos, v) = ProtobufLib.decode_sfixed32(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_SFIXED64) {
+            int64 v;
+            (success, pos, v) = ProtobufLib.decode_sfixed64(pos, buf);
+            return (success, pos, uint256(int256(v)));
+        }
+        if (field_type == TYPE_BOOL) {
+            bool v;
+            (success, pos, v) = ProtobufLib.decode_bool(pos, buf);
+            return (success, pos, v ? 1 : 0);
+        }
+        if (field_type == TYPE_ENUM) {
+            int32 v;
+            (success, pos, v) = ProtobufLib.decode_enum(pos, buf);
+            if (!success) {
+                return (false, pos, 0);
+            }
+
+            // Check that value is within enum range
+            if (v < 0 || uint64(uint32(v)) > field.ref) {
+                return (false, pos, 0);
+            }
+
+            return (true, pos, uint256(uint32(v)));
+        }
+
+        return (false, pos, 0);
+    }
+
+    /// @notice Decode a string or bytes value, as a pointer to a copy of it.
+    function decode_bytes(uint64 pos, bytes memory buf)
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        bool success;
+        uint64 len;
+        (success, pos, len) = ProtobufLib.decode_length_delimited(pos, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Default value must be omitted
+        if (len == 0) {
+            return (false, pos, 0);
+        }
+
+        bytes memory v = ProtobufSupportLib.slice(buf, pos, len);
+        uint256 value;
+        /// @solidity memory-safe-assembly
+        assembly {
+            value := v
+        }
+
+        return (true, pos + len, value);
+    }
+
+    /// @notice Decode an embedded message, which must not be empty.
+    function decode_nested(
+        uint64 pos,
+        bytes memory buf,
+        bytes memory table,
+        uint256 offset
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        bool success;
+        uint64 len;
+        (success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Default value must be omitted
+        if (len == 0) {
+            return (false, pos, 0);
+        }
+
+        return decode_message(pos, buf, len, table, offset);
+    }
+
+    /// @notice Decode a packed repeated field, as a pointer to an array.
+    function decode_packed(
+        uint64 pos,
+        bytes memory buf,
+        Field memory field
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        bool success;
+        uint64 len;
+        (success, pos, len) = ProtobufLib.decode_length_delimited(pos, buf);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Empty packed array must be omitted
+        if (len == 0) {
+            return (false, pos, 0);
+        }
+
+        // Sanity checks
+        if (pos + len < pos) {
+            return (false, pos, 0);
+        }
+
+        uint64 cnt;
+        (success, cnt) = count_packed(pos, buf, len, field.field_type);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Allocated memory
+        uint256 array = allocate((uint256(cnt) + 1) * 32);
+        store_word(array, 0, cnt);
+
+        // Parse the elements
+        uint64 end = pos + len;
+        for (uint64 i = 0; i < cnt; i++) {
+            uint256 value;
+            (success, pos, value) = decode_scalar(pos, buf, field);
+            if (!success) {
+                return (false, pos, 0);
+            }
+
+            store_word(array, i + 1, value);
+        }
+
+        // Decoding must have consumed len bytes
+        if (pos != end) {
+            return (false, pos, 0);
+        }
+
+        return (true, pos, array);
+    }
+
+    /// @notice Count the elements of a packed repeated field, without decoding them.
+    function count_packed(
+        uint64 pos,
+        bytes memory buf,
+        uint64 len,
+        uint8 field_type
+    ) internal pure returns (bool, uint64) {
+        uint64 size = 0;
+        if (field_type == TYPE_FIXED32 || field_type == TYPE_SFIXED32) {
+            size = 4;
+        } else if (field_type == TYPE_FIXED64 || field_type == TYPE_SFIXED64) {
+            size = 8;
+        }
+
+        if (size > 0) {
+            // Elements have a fixed size, so the length must be a multiple of it
+            if (len % size != 0) {
+                return (false, 0);
+            }
+
+            return (true, len / size);
+        }
+
+        // Count the elements, each of which ends with a byte that has its highest bit clear
+        uint64 cnt = 0;
+        for (uint64 i = pos; i < pos + len; i++) {
+            if ((uint8(buf[i]) & 0x80) == 0) {
+                cnt += 1;
+            }
+        }
+
+        return (true, cnt);
+    }
+
+    /// @notice Decode a repeated message field, as a pointer to an array.
+    function decode_repeated_message(
+        uint64 pos,
+        bytes memory buf,
+        uint64 end,
+        bytes memory table,
+        Field memory field
+    )
+        internal
+        pure
+        returns (
+            bool,
+            uint64,
+            uint256
+        )
+    {
+        bool success;
+        uint64 cnt;
+        (success, cnt) = count_repeated_message(pos, buf, end, field.number);
+        if (!success) {
+            return (false, pos, 0);
+        }
+
+        // Allocated memory
+        uint256 array = allocate((uint256(cnt) + 1) * 32);
+        store_word(array, 0, cnt);
+
+        // Parse the elements
+        for (uint64 i = 0; i < cnt; i++) {
+            // Skip over the key, already checked while counting
+            if (i > 0) {
+                (success, pos, , ) = ProtobufLib.decode_key(pos, buf);
+                if (!success) {
+                    return (false, pos, 0);
+                }
+            }
+
+            uint64 len;
+            (success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);
+            if (!success) {
+                return (false, pos, 0);
+            }
+
+            uint256 value;
+            (success, pos, value) = decode_message(pos, buf, len, table, field.ref);
+            if (!success) {
+                return (false, pos, 0);
+            }
+
+            store_word(array, i + 1, value);
+        }
+
+        return (true, pos, array);
+    }
+
+    /// @notice Count the elements of a repeated message field, which are
+    /// consecutive fields with the same number, up to end.
+    function count_repeated_message(
+        uint64 pos,
+        bytes memory buf,
+        uint64 end,
+        uint64 number
+    ) internal pure returns (bool, uint64) {
+        uint64 cnt = 0;
+        while (true) {
+            bool success;
+            uint64 len;
+            (success, pos, len) = ProtobufLib.decode_embedded_message(pos, buf);
+            if (!success) {
+                return (false, cnt);
+            }
+
+            pos += len;
+            cnt += 1;
+
+            if (pos >= end) {
+                break;
+            }
+
+            // Decode next key
+            uint64 field_number;
+            ProtobufLib.WireType wire_type;
+            (success, pos, field_number, wire_type) = ProtobufLib.decode_key(pos, buf);
+            if (!success) {
+                return (false, cnt);
+            }
+
+            // Check if the field number is different
+            if (field_number != number) {
+                break;
+            }
+
+            // Check that the wire type is correct
+            if (wire_type != ProtobufLib.WireType.LengthDelimited) {
+                return (false, cnt);
+            }
+        }
+
+        return (true, cnt);
+    }
+
+    ////////////////////////////////////
+    // Encoding
+    ////////////////////////////////////
+
+    /// @notice Encode the message at ptr, given its table.
+    function encode(uint256 ptr, bytes memory table) internal pure returns (bytes memory) {
+        // Allocate exactly enough bytes, then encode in place
+        bytes memory buf = new bytes(encoded_size(ptr, table, 0));
+        encode_at(0, buf, ptr, table, 0);
+
+        return buf;
+    }
+
+    /// @notice Number of bytes needed to encode the message at ptr, whose table is at offset.
+    function encoded_size(
+        uint256 ptr,
+        bytes memory table,
+        uint256 offset
+    ) internal pure returns (uint64) {
+        uint64 len = 0;
+        uint64 count = field_count(table, offset);
+        for (uint64 i = 0; i < count; i++) {
+            Field memory field = read_field(table, offset, i);
+            len += field_size(load_word(ptr, field.member), table, field);
+        }
+
+        return len;
+    }
+
+    function field_size(
+        uint256 value,
+        bytes memory table,
+        Field memory field
+    ) internal pure returns (uint64) {
+        uint64 key_size = ProtobufSupportLib.size_varint(key(field));
+
+        if ((field.flags & FLAG_PACKED) != 0) {
+            uint64 len = packed_size(value, field.field_type);
+
+            // Empty packed array is omitted
+            if (len == 0) {
+                return 0;
+            }
+
+            return key_size + ProtobufSupportLib.size_varint(len) + len;
+        }
+
+        if ((field.flags & FLAG_REPEATED) != 0) {
+            // Repeated message, each element is wrapped in key and length
+            uint64 len = 0;
+            uint256 cnt = load_word(value, 0);
+            for (uint256 i = 0; i < cnt; i++) {
+                uint64 nested_len = encoded_size(load_word(value, i + 1), table, field.ref);
+                len += key_size + ProtobufSupportLib.size_varint(nested_len) + nested_len;
+            }
+
+            return len;
+        }
+
+        if (field.field_type == TYPE_MESSAGE) {
+            uint64 len = encoded_size(value, table, field.ref);
+
+            // Default value is omitted
+            if (len == 0) {
+                return 0;
+            }
+
+            return key_size + ProtobufSupportLib.size_varint(len) + len;
+        }
+
+        if (field.field_type == TYPE_STRING || field.field_type == TYPE_BYTES) {
+            uint64 len = uint64(load_word(value, 0));
+
+            // Default value is omitted
+            if (len == 0) {
+                return 0;
+            }
+
+            return key_size + ProtobufSupportLib.size_varint(len) + len;
+        }
+
+        // Default value is omitted
+        if (value == 0) {
+            return 0;
+        }
+
+        return key_size + value_size(value, field.field_type);
+    }
+
+    /// @notice Number of bytes needed to encode the elements of the array at ptr.
+    function packed_size(uint256 ptr, uint8 field_type) internal pure returns (uint64) {
+        uint64 len = 0;
+        uint256 cnt = load_word(ptr, 0);
+        for (uint256 i = 0; i < cnt; i++) {
+            len += value_size(load_word(ptr, i + 1), field_type);
+        }
+
+        return len;
+    }
+
+    /// @notice Number of bytes needed to encode a numeric, bool or enum value.
+    function value_size(uint256 value, uint8 field_type) internal pure returns (uint64) {
+        if (field_type == TYPE_INT32 || field_type == TYPE_INT64) {
+            return ProtobufSupportLib.size_int64(int64(int256(value)));
+        }
+        if (field_type == TYPE_SINT32 || field_type == TYPE_SINT64) {
+            return ProtobufSupportLib.size_sint64(int64(int256(value)));
+        }
+        if (field_type == TYPE_FIXED32 || field_type == TYPE_SFIXED32) {
+            return 4;
+        }
+        if (field_type == TYPE_FIXED64 || field_type == TYPE_SFIXED64) {
+            return 8;
+        }
+
+        // Unsigned integers, bools and enums
+        return ProtobufSupportLib.size_varint(uint64(value));
+    }
+
+    /// @notice Encode the message at ptr, whose table is at offset, into buf at pos.
+    /// @return The position after the encoded message.
+    function encode_at(
+        uint64 pos,
+        bytes memory buf,
+        uint256 ptr,
+        bytes memory table,
+        uint256 offset
+    ) internal pure returns (uint64) {
+        uint64 count = field_count(table, offset);
+        for (uint64 i = 0; i < count; i++) {
+            Field memory field = read_field(table, offset, i);
+            pos = encode_field(pos, buf, load_word(ptr, field.member), table, field);
+        }
+
+        return pos;
+    }
+
+    function encode_field(
+        uint64 pos,
+        bytes memory buf,
+        uint256 value,
+        bytes memory table,
+        Field memory field
+    ) internal pure returns (uint64) {
+        if ((field.flags & FLAG_PACKED) != 0) {
+            uint64 len = packed_size(value, field.field_type);
+
+            // Empty packed array is omitted
+            if (len == 0) {
+                return pos;
+            }
+
+            pos = ProtobufSupportLib.write_varint(pos, buf, key(field));
+            pos = ProtobufSupportLib.write_varint(pos, buf, len);
+            uint256 cnt = load_word(value, 0);
+            for (uint256 i = 0; i < cnt; i++) {
+                pos = write_value(pos, buf, load_word(value, i + 1), field.field_type);
+            }
+
+            return pos;
+        }
+
+        if ((field.flags & FLAG_REPEATED) != 0) {
+            // Repeated message, each element is wrapped in key and length
+            uint256 cnt = load_word(value, 0);
+            for (uint256 i = 0; i < cnt; i++) {
+                uint256 element = load_word(value, i + 1);
+                pos = ProtobufSupportLib.write_varint(pos, buf, key(field));
+                pos = ProtobufSupportLib.write_varint(pos, buf, encoded_size(element, table, field.ref));
+                pos = encode_at(pos, buf, element, table, field.ref);
+            }
+
+            return pos;
+        }
+
+        if (field.field_type == TYPE_MESSAGE) {
+            uint64 len = encoded_size(value, table, field.ref);
+
+            // Default value is omitted
+            if (len == 0) {
+                return pos;
+            }
+
+            pos = ProtobufSupportLib.write_varint(pos, buf, key(field));
+            pos = ProtobufSupportLib.write_varint(pos, buf, len);
+            return encode_at(pos, buf, value, table, field.ref);
+        }
+
+        if (field.field_type == TYPE_STRING || field.field_type == TYPE_BYTES) {
+            bytes memory data;
+            /// @solidity memory-safe-assembly
+            assembly {
+                data := value
+            }
+
+            // Default value is omitted
+            if (data.length == 0) {
+                return pos;
+            }
+
+            pos = ProtobufSupportLib.write_varint(pos, buf, key(field));
+            pos = ProtobufSupportLib.write_varint(pos, buf, uint64(data.length));
+            return ProtobufSupportLib.write_bytes(pos, buf, data);
+        }
+
+        // Default value is omitted
+        if (value == 0) {
+            return pos;
+        }
+
+        pos = ProtobufSupportLib.write_varint(pos, buf, key(field));
+        return write_value(pos, buf, value, field.field_type);
+    }
+
+    /// @notice Encode a numeric, bool or enum value into buf at pos.
+    /// @return The position after the encoded value.
+    function write_value(
+        uint64 pos,
+        bytes memory buf,
+        uint256 value,
+        uint8 field_type
+    ) internal pure returns (uint64) {
+        if (field_type == TYPE_INT32 || field_type == TYPE_INT64) {
+            return ProtobufSupportLib.write_int64(pos, buf, int64(int256(value)));
+        }
+        if (field_type == TYPE_SINT32 || field_type == TYPE_SINT64) {
+            return ProtobufSupportLib.write_sint64(pos, buf, int64(int256(value)));
+        }
+        if (field_type == TYPE_FIXED32 || field_type == TYPE_SFIXED32) {
+            return ProtobufSupportLib.write_bits32(pos, buf, uint32(value));
+        }
+        if (field_type == TYPE_FIXED64 || field_type == TYPE_SFIXED64) {
+            return ProtobufSupportLib.write_bits64(pos, buf, uint64(value));
+        }
+
+        // Unsigned integers, bools and enums
+        return ProtobufSupportLib.write_varint(pos, buf, uint64(value));
+    }
+}
+`
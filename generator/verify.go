@@ -0,0 +1,215 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// generateVerifiers generates functions that verify that a singular field
+// has a given value in an encoded message, with its key at a given offset,
+// without decoding the whole message. Fields before it are skipped by
+// find_field, which checks their order and wire types, and the key after it
+// must have a larger field number. Fields of embedded messages are verified
+// through the embedded message's verifiers, like accessors.
+func (g *Generator) generateVerifiers(structName string, fields []*descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	b.P("// Check that the field after the one ending at pos, if any, comes after field_number")
+	b.P("function check_next_field(uint64 pos, bytes memory buf, uint64 end, uint64 field_number) internal pure returns (bool) {")
+	b.Indent()
+	b.P("if (pos == end) {")
+	b.Indent()
+	b.P("return true;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("(bool success, , uint64 next_field_number, ProtobufLib.WireType wire_type) = ProtobufLib.decode_key(pos, buf);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return next_field_number > field_number && check_key(next_field_number, wire_type);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	for _, field := range fields {
+		if isFieldRepeated(field) {
+			continue
+		}
+
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			err := g.generateVerifier(structName, field, b)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		nestedPaths, err := g.accessorPaths(fieldTypeName, map[string]bool{structName: true})
+		if err != nil {
+			return err
+		}
+
+		for _, nestedPath := range nestedPaths {
+			if nestedPath.field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+				continue
+			}
+
+			err := g.generateNestedVerifier(structName, field, fieldTypeName, nestedPath, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateVerifierHeader generates the signatures of a verifier and the search
+// for its field. The generated code leaves the position of the field's value
+// in pos.
+func generateVerifierHeader(name string, fieldNumber int32, valueType string, b *WriteableBuffer) {
+	b.P(fmt.Sprintf("function verify_%s(bytes memory buf, uint64 offset, %s value) internal pure returns (bool) {", name, valueType))
+	b.Indent()
+	b.P(fmt.Sprintf("return verify_%s(0, buf, uint64(buf.length), offset, value);", name))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("function verify_%s(uint64 initial_pos, bytes memory buf, uint64 len, uint64 offset, %s value) internal pure returns (bool) {", name, valueType))
+	b.Indent()
+	b.P("bool success;")
+	b.P("bool found;")
+	b.P("uint64 pos;")
+	b.P(fmt.Sprintf("(success, found, pos) = find_field(initial_pos, buf, len, %d);", fieldNumber))
+	b.P("if (!success || !found) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+}
+
+// generateVerifier generates the verifier of a singular field that isn't an
+// embedded message.
+func (g *Generator) generateVerifier(structName string, field *descriptorpb.FieldDescriptorProto, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+	fieldNumber := field.GetNumber()
+
+	valueType, err := toSolReturnType(field)
+	if err != nil {
+		return errors.New(err.Error() + ": " + structName + "." + fieldName)
+	}
+	key, err := toKey(field)
+	if err != nil {
+		return err
+	}
+
+	b.P(fmt.Sprintf("// Verify that %s.%s has value, with its key at offset", structName, fieldName))
+	generateVerifierHeader(fieldName, fieldNumber, valueType, b)
+
+	b.P("// Key must be at offset, keys are canonical so their size is known")
+	b.P(fmt.Sprintf("if (pos - %d != offset) {", varintSize(key)))
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("%s v;", valueType))
+	err = g.generateAccessorValue(structName, field, "return false;", b)
+	if err != nil {
+		return err
+	}
+
+	b.P("// Field must be within the message")
+	b.P("if (pos > initial_pos + len) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("if (!check_next_field(pos, buf, initial_pos + len, %d)) {", fieldNumber))
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		b.P("return keccak256(bytes(v)) == keccak256(bytes(value));")
+	default:
+		b.P("return v == value;")
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateNestedVerifier generates the verifier of a field of an embedded
+// message, which checks the embedded message's key and bounds then delegates
+// to its codec.
+func (g *Generator) generateNestedVerifier(structName string, field *descriptorpb.FieldDescriptorProto, fieldTypeName string, nestedPath accessorPath, b *WriteableBuffer) error {
+	fieldName := field.GetName()
+	fieldNumber := field.GetNumber()
+
+	valueType, err := toSolReturnType(nestedPath.field)
+	if err != nil {
+		return err
+	}
+
+	b.P(fmt.Sprintf("// Verify that %s.%s.%s has value, with its key at offset", structName, fieldName, strings.ReplaceAll(nestedPath.name, "__", ".")))
+	generateVerifierHeader(fieldName+"__"+nestedPath.name, fieldNumber, valueType, b)
+
+	b.P("uint64 nested_len;")
+	b.P("(success, pos, nested_len) = ProtobufLib.decode_embedded_message(pos, buf);")
+	b.P("if (!success) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Default value must be omitted")
+	b.P("if (nested_len == 0) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Field must be within the message")
+	b.P("if (pos + nested_len > initial_pos + len) {")
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("if (!check_next_field(pos + nested_len, buf, initial_pos + len, %d)) {", fieldNumber))
+	b.Indent()
+	b.P("return false;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P(fmt.Sprintf("return %sCodec.verify_%s(pos, buf, nested_len, offset, value);", fieldTypeName, nestedPath.name))
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
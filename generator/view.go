@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const viewTypeName = supportLibName + ".View"
+
+// parseViewParameter parses the value of a view parameter, either all or
+// <Message>.<field>. The parameter may be repeated to select several fields.
+func (g *Generator) parseViewParameter(value string) error {
+	if value == "all" {
+		g.viewAll = true
+		return nil
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("invalid view %s, allowed values are <all, Message.field>", value)
+	}
+	g.views[value] = true
+
+	return nil
+}
+
+// isViewUsed returns true if any field is decoded as a view.
+func (g *Generator) isViewUsed() bool {
+	return g.viewAll || len(g.views) > 0
+}
+
+// checkViews checks that views refer to known string or bytes fields, and that
+// the other parameters support them. Views point into the decoded buffer, so
+// they can only be decoded from memory and can't be copied to storage.
+func (g *Generator) checkViews() error {
+	if !g.isViewUsed() {
+		return nil
+	}
+
+	if g.backendFlag != backendFlagSolidity {
+		return fmt.Errorf("backend %s does not support views", fromBackendFlag(g.backendFlag))
+	}
+	if g.inputFlag != inputFlagMemory {
+		return fmt.Errorf("views only support input %s", fromInputFlag(inputFlagMemory))
+	}
+	if g.storage {
+		return errors.New("views do not support storage")
+	}
+
+	for view := range g.views {
+		parts := strings.Split(view, ".")
+		descriptor, ok := g.messages[parts[0]]
+		if !ok {
+			return errors.New("view set for unknown message: " + view)
+		}
+
+		found := false
+		for _, field := range descriptor.GetField() {
+			if field.GetName() != parts[1] {
+				continue
+			}
+			found = true
+
+			switch field.GetType() {
+			case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+				descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			default:
+				return errors.New("view set for field that is not string or bytes: " + view)
+			}
+		}
+		if !found {
+			return errors.New("view set for unknown field: " + view)
+		}
+	}
+
+	return nil
+}
+
+// isFieldView returns true if a field is decoded as a view into the decoded
+// buffer rather than copied.
+func (g *Generator) isFieldView(structName string, field *descriptorpb.FieldDescriptorProto) bool {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+	default:
+		return false
+	}
+
+	return g.viewAll || g.views[structName+"."+field.GetName()]
+}
@@ -0,0 +1,270 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// wellKnownPackage is the package of the well-known types. Well-known types
+// are generated like the messages of other files, with names stripped of the
+// package since Solidity has no packages.
+const wellKnownPackage = "google.protobuf"
+
+// wellKnownFiles are the .proto files of the supported well-known types.
+var wellKnownFiles = map[string]bool{
+	"google/protobuf/any.proto":       true,
+	"google/protobuf/duration.proto":  true,
+	"google/protobuf/empty.proto":     true,
+	"google/protobuf/timestamp.proto": true,
+	"google/protobuf/wrappers.proto":  true,
+}
+
+// wellKnownSolidityFiles are the .proto files of the well-known types whose
+// validation or presence is only generated by the solidity backend.
+var wellKnownSolidityFiles = map[string]bool{
+	"google/protobuf/duration.proto":  true,
+	"google/protobuf/timestamp.proto": true,
+	"google/protobuf/wrappers.proto":  true,
+}
+
+// wellKnownWrappers are the supported wrapper types, whose fields carry
+// presence: a wrapper holding the default value is encoded, unlike an absent
+// one.
+var wellKnownWrappers = map[string]bool{
+	"BoolValue":   true,
+	"BytesValue":  true,
+	"Int32Value":  true,
+	"Int64Value":  true,
+	"StringValue": true,
+	"UInt32Value": true,
+	"UInt64Value": true,
+}
+
+// wellKnownUnsupported are the well-known types that aren't generated, since
+// Solidity has no floating-point numbers.
+var wellKnownUnsupported = map[string]bool{
+	"DoubleValue": true,
+	"FloatValue":  true,
+}
+
+// wellKnownFieldNames renames the fields of well-known types whose names are
+// Solidity keywords, e.g. the seconds of Timestamp and Duration.
+var wellKnownFieldNames = map[string]string{
+	"seconds": "secs",
+}
+
+// isWellKnownFile returns true if a .proto file holds supported well-known
+// types, whose package is allowed.
+func isWellKnownFile(fileName string, packageName string) bool {
+	return packageName == wellKnownPackage && wellKnownFiles[fileName]
+}
+
+// trimWellKnownPackage removes the package from the fully-qualified name of a
+// well-known type, e.g. .google.protobuf.Any becomes .Any.
+func trimWellKnownPackage(typeName string) string {
+	if strings.HasPrefix(typeName, "."+wellKnownPackage+".") {
+		return "." + strings.TrimPrefix(typeName, "."+wellKnownPackage+".")
+	}
+	return typeName
+}
+
+// renameWellKnownFields renames the fields of the well-known types of a .proto
+// file, so their struct members are valid identifiers.
+func renameWellKnownFields(protoFile *descriptorpb.FileDescriptorProto) {
+	if !isWellKnownFile(protoFile.GetName(), protoFile.GetPackage()) {
+		return
+	}
+
+	for _, descriptor := range protoFile.GetMessageType() {
+		for _, field := range descriptor.GetField() {
+			if name, ok := wellKnownFieldNames[field.GetName()]; ok {
+				field.Name = proto.String(name)
+			}
+		}
+	}
+}
+
+// protoFieldName returns the name of a field of a message in its .proto file,
+// which differs from its struct member for renamed fields of well-known types.
+func (g *Generator) protoFieldName(structName string, field *descriptorpb.FieldDescriptorProto) string {
+	if g.packages[structName] == wellKnownPackage {
+		for name, renamed := range wellKnownFieldNames {
+			if field.GetName() == renamed {
+				return name
+			}
+		}
+	}
+	return field.GetName()
+}
+
+// fileMessages returns the messages of a .proto file that are generated,
+// leaving out unsupported well-known types.
+func fileMessages(protoFile *descriptorpb.FileDescriptorProto) []*descriptorpb.DescriptorProto {
+	if protoFile.GetPackage() != wellKnownPackage {
+		return protoFile.GetMessageType()
+	}
+
+	messages := []*descriptorpb.DescriptorProto{}
+	for _, descriptor := range protoFile.GetMessageType() {
+		if !wellKnownUnsupported[descriptor.GetName()] {
+			messages = append(messages, descriptor)
+		}
+	}
+
+	return messages
+}
+
+// checkWellKnownType checks that a field doesn't have an unsupported
+// well-known type.
+func checkWellKnownType(field *descriptorpb.FieldDescriptorProto) error {
+	typeName := field.GetTypeName()
+	if !strings.HasPrefix(typeName, "."+wellKnownPackage+".") {
+		return nil
+	}
+
+	if wellKnownUnsupported[trimWellKnownPackage(typeName)[1:]] {
+		return fmt.Errorf("well-known type %s is unsupported", typeName[1:])
+	}
+
+	return nil
+}
+
+// checkWellKnownBackend checks that the backend supports the well-known types
+// of the requested files.
+func (g *Generator) checkWellKnownBackend() error {
+	if g.backendFlag == backendFlagSolidity {
+		return nil
+	}
+
+	for _, protoFile := range g.request.GetProtoFile() {
+		if protoFile.GetPackage() == wellKnownPackage && wellKnownSolidityFiles[protoFile.GetName()] {
+			return fmt.Errorf("backend %s does not support %s", fromBackendFlag(g.backendFlag), protoFile.GetName())
+		}
+	}
+
+	return nil
+}
+
+// isWrapper returns true if a message is a well-known wrapper type.
+func (g *Generator) isWrapper(structName string) bool {
+	return g.packages[structName] == wellKnownPackage && wellKnownWrappers[structName]
+}
+
+// isFieldWrapper returns true if a field is a singular well-known wrapper,
+// whose presence is encoded.
+func (g *Generator) isFieldWrapper(field *descriptorpb.FieldDescriptorProto) bool {
+	if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE || isFieldRepeated(field) {
+		return false
+	}
+
+	return g.isWrapper(trimWellKnownPackage(field.GetTypeName())[1:])
+}
+
+// isValidated returns true if a message is a well-known type whose values are
+// validated by the decoder, beyond their encoding.
+func (g *Generator) isValidated(structName string) bool {
+	if g.packages[structName] != wellKnownPackage {
+		return false
+	}
+
+	return structName == "Timestamp" || structName == "Duration"
+}
+
+// generateWellKnownFunctions generates the functions of the codec libraries of
+// well-known types: validation and conversion of timestamps and durations, as
+// defined in their .proto files.
+func (g *Generator) generateWellKnownFunctions(structName string, b *WriteableBuffer) {
+	if g.packages[structName] != wellKnownPackage {
+		return
+	}
+
+	switch structName {
+	case "Timestamp":
+		b.P("// Timestamps are from 0001-01-01T00:00:00Z to 9999-12-31T23:59:59Z inclusive")
+		b.P("int64 constant MIN_SECONDS = -62135596800;")
+		b.P("int64 constant MAX_SECONDS = 253402300799;")
+		b.P()
+
+		b.P("// Check that instance is within range, with non-negative nanos")
+		b.P("function is_valid(Timestamp memory instance) internal pure returns (bool) {")
+		b.Indent()
+		b.P("if (instance.secs < MIN_SECONDS || instance.secs > MAX_SECONDS) {")
+		b.Indent()
+		b.P("return false;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return instance.nanos >= 0 && instance.nanos <= 999999999;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Unix time of instance in seconds, rounded down")
+		b.P("function to_unix_seconds(Timestamp memory instance) internal pure returns (int64) {")
+		b.Indent()
+		b.P("return instance.secs;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Timestamp of a Unix time in seconds, which must be within range")
+		b.P("function from_unix_seconds(int64 unix_seconds) internal pure returns (Timestamp memory) {")
+		b.Indent()
+		b.P("require(unix_seconds >= MIN_SECONDS && unix_seconds <= MAX_SECONDS, \"Timestamp: out of range\");")
+		b.P()
+		b.P("Timestamp memory instance;")
+		b.P("instance.secs = unix_seconds;")
+		b.P()
+		b.P("return instance;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+	case "Duration":
+		b.P("// Durations are within about +-10,000 years")
+		b.P("int64 constant MAX_SECONDS = 315576000000;")
+		b.P()
+
+		b.P("// Check that instance is within range, with nanos of the same sign as seconds")
+		b.P("function is_valid(Duration memory instance) internal pure returns (bool) {")
+		b.Indent()
+		b.P("if (instance.secs < -MAX_SECONDS || instance.secs > MAX_SECONDS) {")
+		b.Indent()
+		b.P("return false;")
+		b.Unindent()
+		b.P("}")
+		b.P("if (instance.nanos < -999999999 || instance.nanos > 999999999) {")
+		b.Indent()
+		b.P("return false;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("return (instance.secs >= 0 && instance.nanos >= 0) || (instance.secs <= 0 && instance.nanos <= 0);")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Seconds of instance, rounded towards zero")
+		b.P("function to_seconds(Duration memory instance) internal pure returns (int64) {")
+		b.Indent()
+		b.P("return instance.secs;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+
+		b.P("// Duration of a number of seconds, which must be within range")
+		b.P("function from_seconds(int64 duration_seconds) internal pure returns (Duration memory) {")
+		b.Indent()
+		b.P("require(duration_seconds >= -MAX_SECONDS && duration_seconds <= MAX_SECONDS, \"Duration: out of range\");")
+		b.P()
+		b.P("Duration memory instance;")
+		b.P("instance.secs = duration_seconds;")
+		b.P()
+		b.P("return instance;")
+		b.Unindent()
+		b.P("}")
+		b.P()
+	}
+}
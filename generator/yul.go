@@ -0,0 +1,1042 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// yulFunction is a Yul function shared by the codecs of the yul backend.
+type yulFunction struct {
+	name   string
+	deps   []string
+	source string
+}
+
+// yulFunctions holds the Yul functions that generated codecs may use, in order
+// of emission. Positions are memory pointers, and a returned position of 0
+// signals invalid input.
+var yulFunctions = []yulFunction{
+	{"pb_varint", nil, `// Decode a varint at p, before end
+function pb_varint(p, end) -> v, q {
+    for { let i := 0 } lt(i, 10) { i := add(i, 1) } {
+        if iszero(lt(p, end)) {
+            leave
+        }
+        let b := byte(0, mload(p))
+        v := or(v, shl(mul(i, 7), and(b, 0x7f)))
+        p := add(p, 1)
+        if lt(b, 0x80) {
+            // [STRICT] Check for trailing zeroes if more than one byte is used
+            if and(gt(i, 0), iszero(b)) {
+                leave
+            }
+            // [STRICT] If all 10 bytes are used, the last byte must be at most 1
+            if and(eq(i, 9), gt(b, 1)) {
+                leave
+            }
+            q := p
+            leave
+        }
+    }
+}`},
+	{"pb_key", []string{"pb_varint"}, `// Decode a key at p, before end
+function pb_key(p, end) -> field_number, wire_type, q {
+    let k
+    k, q := pb_varint(p, end)
+    field_number := shr(3, k)
+    wire_type := and(k, 7)
+    // Wire type must be Varint, Bits64, LengthDelimited or Bits32
+    if or(gt(wire_type, 5), or(eq(wire_type, 3), eq(wire_type, 4))) {
+        q := 0
+    }
+}`},
+	{"pb_length", []string{"pb_varint"}, `// Decode a length prefix at p, whose payload must end before end
+function pb_length(p, end) -> length, q {
+    length, q := pb_varint(p, end)
+    if gt(length, sub(end, q)) {
+        q := 0
+    }
+}`},
+	{"pb_fixed", nil, `// Decode n little-endian bytes at p, before end
+function pb_fixed(p, end, n) -> v, q {
+    if gt(add(p, n), end) {
+        leave
+    }
+    for { let i := 0 } lt(i, n) { i := add(i, 1) } {
+        v := or(v, shl(mul(i, 8), byte(0, mload(add(p, i)))))
+    }
+    q := add(p, n)
+}`},
+	{"pb_int32", []string{"pb_varint"}, `function pb_int32(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    v := signextend(7, v)
+    // [STRICT] Check that the value is within int32 range, as an int64
+    if or(slt(v, sub(0, 0x80000000)), sgt(v, 0x7fffffff)) {
+        q := 0
+    }
+}`},
+	{"pb_int64", []string{"pb_varint"}, `function pb_int64(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    v := signextend(7, v)
+}`},
+	{"pb_uint32", []string{"pb_varint"}, `function pb_uint32(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    // [STRICT] Check that the value is within uint32 range
+    if gt(v, 0xffffffff) {
+        q := 0
+    }
+}`},
+	{"pb_sint32", []string{"pb_varint"}, `function pb_sint32(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    // [STRICT] Check that the value is within uint32 range
+    if gt(v, 0xffffffff) {
+        q := 0
+    }
+    // Zig-zag decoding, sign-extended to 256 bits
+    v := xor(shr(1, v), sub(0, and(v, 1)))
+}`},
+	{"pb_sint64", []string{"pb_varint"}, `function pb_sint64(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    // Zig-zag decoding, sign-extended to 256 bits
+    v := xor(shr(1, v), sub(0, and(v, 1)))
+}`},
+	{"pb_bool", []string{"pb_varint"}, `function pb_bool(p, end) -> v, q {
+    v, q := pb_varint(p, end)
+    // [STRICT] Check that the value is 0 or 1
+    if gt(v, 1) {
+        q := 0
+    }
+}`},
+	{"pb_enum", []string{"pb_int32"}, `function pb_enum(p, end, max) -> v, q {
+    v, q := pb_int32(p, end)
+    // Check that the value is within the enum's range, which also rejects negative values
+    if gt(v, max) {
+        q := 0
+    }
+}`},
+	{"pb_fixed32", []string{"pb_fixed"}, `function pb_fixed32(p, end) -> v, q {
+    v, q := pb_fixed(p, end, 4)
+}`},
+	{"pb_fixed64", []string{"pb_fixed"}, `function pb_fixed64(p, end) -> v, q {
+    v, q := pb_fixed(p, end, 8)
+}`},
+	{"pb_sfixed32", []string{"pb_fixed"}, `function pb_sfixed32(p, end) -> v, q {
+    v, q := pb_fixed(p, end, 4)
+    v := signextend(3, v)
+}`},
+	{"pb_sfixed64", []string{"pb_fixed"}, `function pb_sfixed64(p, end) -> v, q {
+    v, q := pb_fixed(p, end, 8)
+    v := signextend(7, v)
+}`},
+	{"pb_count_varints", nil, `// Number of varints between p and end, which is the number of bytes with the
+// highest bit cleared
+function pb_count_varints(p, end) -> cnt {
+    for {} lt(p, end) { p := add(p, 1) } {
+        if lt(byte(0, mload(p)), 0x80) {
+            cnt := add(cnt, 1)
+        }
+    }
+}`},
+	{"pb_alloc", nil, `// Allocate memory, which is not zeroed
+function pb_alloc(n) -> ptr {
+    ptr := mload(0x40)
+    mstore(0x40, add(ptr, n))
+}`},
+	{"pb_copy", nil, `// Copy length bytes of memory from src to dst, leaving the bytes after them in dst as is
+function pb_copy(src, dst, length) {
+    for {} gt(length, 31) { length := sub(length, 32) } {
+        mstore(dst, mload(src))
+        src := add(src, 32)
+        dst := add(dst, 32)
+    }
+    if length {
+        let mask := sub(shl(mul(sub(32, length), 8), 1), 1)
+        mstore(dst, or(and(mload(src), not(mask)), and(mload(dst), mask)))
+    }
+}`},
+	{"pb_bytes", []string{"pb_length", "pb_alloc", "pb_copy"}, `// Decode a length-delimited string or bytes at p, before end, into a new bytes array
+function pb_bytes(p, end) -> ptr, q {
+    let length
+    length, q := pb_length(p, end)
+    // [STRICT] Default value must be omitted
+    if iszero(length) {
+        q := 0
+    }
+    if iszero(q) {
+        leave
+    }
+    let rounded := and(add(length, 31), not(31))
+    ptr := pb_alloc(add(rounded, 32))
+    mstore(ptr, length)
+    // Zero the last word, so that bytes after the copied bytes are clean
+    mstore(add(ptr, rounded), 0)
+    pb_copy(q, add(ptr, 32), length)
+    q := add(q, length)
+}`},
+	{"pb_size_varint", nil, `// Number of bytes needed to encode a varint
+function pb_size_varint(v) -> n {
+    n := 1
+    for {} gt(v, 0x7f) { v := shr(7, v) } {
+        n := add(n, 1)
+    }
+}`},
+	{"pb_write_varint", nil, `// Encode a varint at p, returns the position after it
+function pb_write_varint(p, v) -> q {
+    for {} gt(v, 0x7f) { v := shr(7, v) } {
+        mstore8(p, or(and(v, 0x7f), 0x80))
+        p := add(p, 1)
+    }
+    mstore8(p, v)
+    q := add(p, 1)
+}`},
+	{"pb_write_fixed", nil, `// Encode the n lowest bytes of v at p in little-endian order, returns the position after them
+function pb_write_fixed(p, v, n) -> q {
+    for { let i := 0 } lt(i, n) { i := add(i, 1) } {
+        mstore8(add(p, i), shr(mul(i, 8), v))
+    }
+    q := add(p, n)
+}`},
+	{"pb_zigzag", nil, `// Zig-zag encoding of a sign-extended value
+function pb_zigzag(v) -> z {
+    z := and(xor(shl(1, v), sar(255, v)), 0xffffffffffffffff)
+}`},
+}
+
+// yulCancunCopySource replaces pb_copy for EVM versions that have the MCOPY
+// instruction.
+const yulCancunCopySource = `// Copy length bytes of memory from src to dst
+function pb_copy(src, dst, length) {
+    mcopy(dst, src, length)
+}`
+
+// writeYulFunctions writes the Yul functions in used, and the functions they
+// depend on.
+func (g *Generator) writeYulFunctions(used map[string]bool, b *WriteableBuffer) {
+	for i := len(yulFunctions) - 1; i >= 0; i-- {
+		if used[yulFunctions[i].name] {
+			for _, dep := range yulFunctions[i].deps {
+				used[dep] = true
+			}
+		}
+	}
+
+	for _, f := range yulFunctions {
+		if !used[f.name] {
+			continue
+		}
+
+		source := f.source
+		if f.name == "pb_copy" && g.evmFlag == evmFlagCancun {
+			source = yulCancunCopySource
+		}
+		writeYulSource(source, b)
+		b.P()
+	}
+}
+
+// writeYulSource writes multi-line source at the current indentation.
+func writeYulSource(source string, b *WriteableBuffer) {
+	for _, line := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+		if line == "" {
+			b.P()
+		} else {
+			b.P(line)
+		}
+	}
+}
+
+// toYulDecodeFunction returns the Yul function that decodes a value of a
+// numeric, bool or enum field.
+func toYulDecodeFunction(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32:
+		return "pb_int32", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64:
+		return "pb_int64", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32:
+		return "pb_uint32", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64:
+		return "pb_varint", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32:
+		return "pb_sint32", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return "pb_sint64", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return "pb_fixed32", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return "pb_fixed64", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "pb_sfixed32", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return "pb_sfixed64", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return "pb_bool", nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return "pb_enum", nil
+	}
+
+	return "", errors.New("unsupported field type " + field.GetType().String())
+}
+
+// toYulEncodedValue returns the Yul expression of the value to encode for a
+// numeric, bool or enum member word v, which is 0 if and only if the member
+// has its default value.
+func toYulEncodedValue(field *descriptorpb.FieldDescriptorProto, v string) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32:
+		// Negative values are sign-extended to 64 bits
+		return fmt.Sprintf("and(signextend(3, %s), 0xffffffffffffffff)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return fmt.Sprintf("and(%s, 0xffffffffffffffff)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return fmt.Sprintf("and(%s, 0xffffffff)", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32:
+		return fmt.Sprintf("pb_zigzag(signextend(3, %s))", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return fmt.Sprintf("pb_zigzag(signextend(7, %s))", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return fmt.Sprintf("iszero(iszero(%s))", v), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return fmt.Sprintf("and(%s, 0xff)", v), nil
+	}
+
+	return "", errors.New("unsupported field type " + field.GetType().String())
+}
+
+// toYulValueSize returns the Yul expression of the number of bytes needed to
+// encode value x of a numeric, bool or enum field.
+func toYulValueSize(field *descriptorpb.FieldDescriptorProto, x string) string {
+	if size := toPackedElementSize(field.GetType()); size > 0 {
+		return fmt.Sprint(size)
+	}
+	return fmt.Sprintf("pb_size_varint(%s)", x)
+}
+
+// toYulValueWrite returns the Yul expression that encodes value x of a
+// numeric, bool or enum field at p.
+func toYulValueWrite(field *descriptorpb.FieldDescriptorProto, x string) string {
+	if size := toPackedElementSize(field.GetType()); size > 0 {
+		return fmt.Sprintf("pb_write_fixed(p, %s, %d)", x, size)
+	}
+	return fmt.Sprintf("pb_write_varint(p, %s)", x)
+}
+
+// yulAllocatedMessages returns the messages that the decoders of messages
+// allocate with their default value: elements of repeated fields and the
+// messages they embed.
+func (g *Generator) yulAllocatedMessages(messages []string) (map[string]bool, error) {
+	allocated := make(map[string]bool)
+
+	var visit func(structName string) error
+	visit = func(structName string) error {
+		if allocated[structName] {
+			return nil
+		}
+		allocated[structName] = true
+
+		for _, field := range g.messages[structName].GetField() {
+			if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE || isFieldRepeated(field) {
+				continue
+			}
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+			err = visit(fieldTypeName)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, message := range messages {
+		for _, field := range g.messages[message].GetField() {
+			if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE || !isFieldRepeated(field) {
+				continue
+			}
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return nil, err
+			}
+			err = visit(fieldTypeName)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return allocated, nil
+}
+
+// generateYulCodec generates the functions of a message's codec library as
+// inline assembly, holding a Yul function for the message and for each message
+// it embeds, which decode and encode directly in memory laid out as Solidity
+// structs.
+func (g *Generator) generateYulCodec(structName string, b *WriteableBuffer) error {
+	messages, err := g.tableMessages(structName, nil)
+	if err != nil {
+		return err
+	}
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagDecoder {
+		err = g.generateYulDecoder(structName, messages, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.generateFlag == generateFlagAll || g.generateFlag == generateFlagEncoder {
+		err = g.generateYulEncoder(structName, messages, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateYulDecoder generates the decode function of a message's codec
+// library.
+func (g *Generator) generateYulDecoder(structName string, messages []string, b *WriteableBuffer) error {
+	allocated, err := g.yulAllocatedMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	// Generate Yul functions first, to know which shared functions they use
+	used := map[string]bool{"pb_key": true}
+	functions := &WriteableBuffer{}
+	for _, message := range messages {
+		err = g.generateYulMessageDecoder(message, used, functions)
+		if err != nil {
+			return err
+		}
+	}
+	for _, message := range messages {
+		if allocated[message] {
+			err = g.generateYulMessageAllocator(message, used, functions)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	b.P(fmt.Sprintf("function decode(uint64 initial_pos, bytes memory buf, uint64 len) internal pure returns (bool, uint64, %s memory) {", structName))
+	b.Indent()
+	b.P(fmt.Sprintf("%s memory instance;", structName))
+	b.P("bool success;")
+	b.P("uint64 pos;")
+	b.P()
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	g.writeYulFunctions(used, b)
+	writeYulSource(functions.String(), b)
+	b.P("let start_pos := and(initial_pos, 0xffffffffffffffff)")
+	b.P("let end_pos := add(start_pos, and(len, 0xffffffffffffffff))")
+	b.P()
+	b.P("// Check that the message is within the buffer")
+	b.P("if iszero(gt(end_pos, mload(buf))) {")
+	b.Indent()
+	b.P("let data := add(buf, 32)")
+	b.P(fmt.Sprintf("let q := decode_msg_%s(instance, add(data, start_pos), add(data, end_pos))", structName))
+	b.P("if q {")
+	b.Indent()
+	b.P("success := 1")
+	b.P("pos := sub(q, data)")
+	b.Unindent()
+	b.P("}")
+	b.Unindent()
+	b.P("}")
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return (success, pos, instance);")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateYulMessageDecoder generates the Yul function that decodes a message
+// into the default instance at ptr, between p and end. Shared functions it
+// calls are added to used.
+func (g *Generator) generateYulMessageDecoder(structName string, used map[string]bool, b *WriteableBuffer) error {
+	fields := g.messages[structName].GetField()
+
+	b.P(fmt.Sprintf("function decode_msg_%s(ptr, p, end) -> q {", structName))
+	b.Indent()
+	b.P("let previous := 0")
+	b.P("for {} lt(p, end) {} {")
+	b.Indent()
+	b.P("let field_number, w")
+	b.P("field_number, w, p := pb_key(p, end)")
+	b.P("if iszero(p) {")
+	b.P("    leave")
+	b.P("}")
+	b.P("// [STRICT] Check that the field number is within bounds and strictly increasing")
+	b.P(fmt.Sprintf("if or(gt(field_number, %d), iszero(gt(field_number, previous))) {", len(fields)))
+	b.P("    leave")
+	b.P("}")
+	b.P()
+	err := g.generateYulFieldDispatch(fields, g.memberIndexes(fields), used, b)
+	if err != nil {
+		return err
+	}
+	b.P()
+	b.P("previous := field_number")
+	b.Unindent()
+	b.P("}")
+	b.P("q := p")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateYulFieldDispatch generates a binary search over fields, whose struct
+// members are at indexes members, that decodes the field of number field_number.
+func (g *Generator) generateYulFieldDispatch(fields []*descriptorpb.FieldDescriptorProto, members []int, used map[string]bool, b *WriteableBuffer) error {
+	// Messages without fields reject any field, which the bounds check already does
+	if len(fields) == 0 {
+		b.P("leave")
+		return nil
+	}
+
+	if len(fields) > 1 {
+		mid := len(fields) / 2
+
+		b.P(fmt.Sprintf("switch lt(field_number, %d)", fields[mid].GetNumber()))
+		b.P("case 1 {")
+		b.Indent()
+		err := g.generateYulFieldDispatch(fields[:mid], members[:mid], used, b)
+		if err != nil {
+			return err
+		}
+		b.Unindent()
+		b.P("}")
+		b.P("default {")
+		b.Indent()
+		err = g.generateYulFieldDispatch(fields[mid:], members[mid:], used, b)
+		if err != nil {
+			return err
+		}
+		b.Unindent()
+		b.P("}")
+		return nil
+	}
+
+	return g.generateYulFieldDecoder(fields[0], members[0], used, b)
+}
+
+// generateYulFieldDecoder generates the Yul code that decodes a field into the
+// member at index of the instance at ptr.
+func (g *Generator) generateYulFieldDecoder(field *descriptorpb.FieldDescriptorProto, index int, used map[string]bool, b *WriteableBuffer) error {
+	wireType, err := toWireType(field)
+	if err != nil {
+		return err
+	}
+	member := fmt.Sprintf("add(ptr, %d)", index*32)
+
+	b.P(fmt.Sprintf("// Field %s", field.GetName()))
+	b.P(fmt.Sprintf("if iszero(eq(w, %d)) {", wireType))
+	b.P("    leave")
+	b.P("}")
+
+	switch {
+	case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING ||
+		field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		used["pb_bytes"] = true
+
+		b.P("let v")
+		b.P("v, p := pb_bytes(p, end)")
+		b.P("if iszero(p) {")
+		b.P("    leave")
+		b.P("}")
+		b.P(fmt.Sprintf("mstore(%s, v)", member))
+
+	case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		fieldTypeName, err := toSolMessageOrEnumName(field)
+		if err != nil {
+			return err
+		}
+		used["pb_length"] = true
+
+		if !isFieldRepeated(field) {
+			b.P("let length")
+			b.P("length, p := pb_length(p, end)")
+			b.P("// [STRICT] Default value must be omitted")
+			b.P("if or(iszero(p), iszero(length)) {")
+			b.P("    leave")
+			b.P("}")
+			b.P("// Decode into the default instance")
+			b.P(fmt.Sprintf("p := decode_msg_%s(mload(%s), p, add(p, length))", fieldTypeName, member))
+			b.P("if iszero(p) {")
+			b.P("    leave")
+			b.P("}")
+			break
+		}
+
+		b.P("// Count the elements, which are consecutive fields with the same number")
+		b.P("let cnt := 0")
+		b.P("for { let c := p } 1 {} {")
+		b.Indent()
+		b.P("let length")
+		b.P("length, c := pb_length(c, end)")
+		b.P("if iszero(c) {")
+		b.P("    leave")
+		b.P("}")
+		b.P("c := add(c, length)")
+		b.P("cnt := add(cnt, 1)")
+		b.P("if iszero(lt(c, end)) {")
+		b.P("    break")
+		b.P("}")
+		b.P("let n, t")
+		b.P("n, t, c := pb_key(c, end)")
+		b.P("if iszero(c) {")
+		b.P("    leave")
+		b.P("}")
+		b.P(fmt.Sprintf("if iszero(eq(n, %d)) {", field.GetNumber()))
+		b.P("    break")
+		b.P("}")
+		b.P(fmt.Sprintf("if iszero(eq(t, %d)) {", wireType))
+		b.P("    leave")
+		b.P("}")
+		b.Unindent()
+		b.P("}")
+		b.P()
+		b.P("let arr := pb_alloc(mul(add(cnt, 1), 32))")
+		b.P("mstore(arr, cnt)")
+		b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+		b.Indent()
+		b.P("// Skip the key of each element after the first, which was checked while counting")
+		b.P("if i {")
+		b.P("    let n, t")
+		b.P("    n, t, p := pb_key(p, end)")
+		b.P("}")
+		b.P("let length")
+		b.P("length, p := pb_length(p, end)")
+		b.P(fmt.Sprintf("let element := alloc_msg_%s()", fieldTypeName))
+		b.P(fmt.Sprintf("p := decode_msg_%s(element, p, add(p, length))", fieldTypeName))
+		b.P("if iszero(p) {")
+		b.P("    leave")
+		b.P("}")
+		b.P("mstore(add(arr, mul(add(i, 1), 32)), element)")
+		b.Unindent()
+		b.P("}")
+		b.P(fmt.Sprintf("mstore(%s, arr)", member))
+		used["pb_alloc"] = true
+
+	default:
+		fn, err := toYulDecodeFunction(field)
+		if err != nil {
+			return err
+		}
+		used[fn] = true
+
+		maxArg := ""
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+			maxArg = fmt.Sprintf(", %d", g.enumMaxes[fieldTypeName])
+		}
+
+		if !isFieldRepeated(field) {
+			b.P("let v")
+			b.P(fmt.Sprintf("v, p := %s(p, end%s)", fn, maxArg))
+			b.P("// [STRICT] Default value must be omitted")
+			b.P("if or(iszero(p), iszero(v)) {")
+			b.P("    leave")
+			b.P("}")
+			b.P(fmt.Sprintf("mstore(%s, v)", member))
+			break
+		}
+
+		used["pb_length"] = true
+		used["pb_alloc"] = true
+
+		b.P("let length")
+		b.P("length, p := pb_length(p, end)")
+		b.P("// [STRICT] Empty packed array must be omitted")
+		b.P("if or(iszero(p), iszero(length)) {")
+		b.P("    leave")
+		b.P("}")
+		b.P("let stop := add(p, length)")
+		if size := toPackedElementSize(field.GetType()); size > 0 {
+			b.P(fmt.Sprintf("if mod(length, %d) {", size))
+			b.P("    leave")
+			b.P("}")
+			b.P(fmt.Sprintf("let cnt := div(length, %d)", size))
+		} else {
+			used["pb_count_varints"] = true
+			b.P("let cnt := pb_count_varints(p, stop)")
+		}
+		b.P("let arr := pb_alloc(mul(add(cnt, 1), 32))")
+		b.P("mstore(arr, cnt)")
+		b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+		b.Indent()
+		b.P("let v")
+		b.P(fmt.Sprintf("v, p := %s(p, stop%s)", fn, maxArg))
+		b.P("if iszero(p) {")
+		b.P("    leave")
+		b.P("}")
+		b.P("mstore(add(arr, mul(add(i, 1), 32)), v)")
+		b.Unindent()
+		b.P("}")
+		b.P("// Decoding must have consumed length bytes")
+		b.P("if iszero(eq(p, stop)) {")
+		b.P("    leave")
+		b.P("}")
+		b.P(fmt.Sprintf("mstore(%s, arr)", member))
+	}
+
+	return nil
+}
+
+// generateYulMessageAllocator generates the Yul function that allocates a
+// message with its default value, as Solidity does for a memory struct.
+func (g *Generator) generateYulMessageAllocator(structName string, used map[string]bool, b *WriteableBuffer) error {
+	fields := g.messages[structName].GetField()
+	used["pb_alloc"] = true
+
+	b.P(fmt.Sprintf("function alloc_msg_%s() -> ptr {", structName))
+	b.Indent()
+	size := len(fields) * 32
+	if len(fields) == 0 {
+		// Messages without fields have a placeholder member
+		size = 32
+	}
+	b.P(fmt.Sprintf("ptr := pb_alloc(%d)", size))
+	members := g.memberIndexes(fields)
+	for i, field := range fields {
+		member := fmt.Sprintf("add(ptr, %d)", members[i]*32)
+		switch {
+		case isFieldRepeated(field),
+			field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING,
+			field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			// Empty dynamic members point to the zero slot
+			b.P(fmt.Sprintf("mstore(%s, 0x60)", member))
+		case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+			b.P(fmt.Sprintf("mstore(%s, alloc_msg_%s())", member, fieldTypeName))
+		default:
+			b.P(fmt.Sprintf("mstore(%s, 0)", member))
+		}
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateYulEncoder generates the encode, encoded_size and encode_at functions
+// of a message's codec library.
+func (g *Generator) generateYulEncoder(structName string, messages []string, b *WriteableBuffer) error {
+	// Generate Yul functions first, to know which shared functions they use
+	used := map[string]bool{"pb_write_varint": true}
+	sizeFunctions := &WriteableBuffer{}
+	for _, message := range messages {
+		err := g.generateYulMessageSize(message, used, sizeFunctions)
+		if err != nil {
+			return err
+		}
+	}
+	sizeUsed := make(map[string]bool)
+	for name := range used {
+		sizeUsed[name] = true
+	}
+	delete(sizeUsed, "pb_write_varint")
+
+	encodeFunctions := &WriteableBuffer{}
+	for _, message := range messages {
+		err := g.generateYulMessageEncoder(message, used, encodeFunctions)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.P(fmt.Sprintf("function encode(%s memory instance) internal pure returns (bytes memory) {", structName))
+	b.Indent()
+	b.P("bytes memory buf = new bytes(encoded_size(instance));")
+	b.P("encode_at(0, buf, instance);")
+	b.P()
+	b.P("return buf;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Number of bytes needed to encode instance")
+	b.P(fmt.Sprintf("function encoded_size(%s memory instance) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("uint64 size;")
+	b.P()
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	g.writeYulFunctions(sizeUsed, b)
+	writeYulSource(sizeFunctions.String(), b)
+	b.P(fmt.Sprintf("size := size_msg_%s(instance)", structName))
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return size;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	b.P("// Encode instance into buf at pos, which must have enough bytes, returns the new pos")
+	b.P(fmt.Sprintf("function encode_at(uint64 pos, bytes memory buf, %s memory instance) internal pure returns (uint64) {", structName))
+	b.Indent()
+	b.P("/// @solidity memory-safe-assembly")
+	b.P("assembly {")
+	b.Indent()
+	g.writeYulFunctions(used, b)
+	writeYulSource(sizeFunctions.String(), b)
+	writeYulSource(encodeFunctions.String(), b)
+	b.P("let data := add(buf, 32)")
+	b.P(fmt.Sprintf("pos := sub(encode_msg_%s(instance, add(data, and(pos, 0xffffffffffffffff))), data)", structName))
+	b.Unindent()
+	b.P("}")
+	b.P()
+	b.P("return pos;")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	generateUncachedSizes(structName, b)
+
+	return nil
+}
+
+// generateYulMessageSize generates the Yul function that returns the number of
+// bytes needed to encode the instance at ptr.
+func (g *Generator) generateYulMessageSize(structName string, used map[string]bool, b *WriteableBuffer) error {
+	fields := g.messages[structName].GetField()
+	used["pb_size_varint"] = true
+
+	b.P(fmt.Sprintf("function size_msg_%s(ptr) -> n {", structName))
+	b.Indent()
+	members := g.memberIndexes(fields)
+	for i, field := range fields {
+		key, err := toKey(field)
+		if err != nil {
+			return err
+		}
+		keySize := varintSize(key)
+
+		b.P(fmt.Sprintf("// Field %s", field.GetName()))
+		b.P("{")
+		b.Indent()
+		b.P(fmt.Sprintf("let v := mload(add(ptr, %d))", members[i]*32))
+
+		switch {
+		case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING ||
+			field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			b.P("let length := mload(v)")
+			b.P("if length {")
+			b.P(fmt.Sprintf("    n := add(n, add(%d, add(pb_size_varint(length), length)))", keySize))
+			b.P("}")
+
+		case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+
+			if !isFieldRepeated(field) {
+				b.P(fmt.Sprintf("let length := size_msg_%s(v)", fieldTypeName))
+				b.P("if length {")
+				b.P(fmt.Sprintf("    n := add(n, add(%d, add(pb_size_varint(length), length)))", keySize))
+				b.P("}")
+				break
+			}
+
+			b.P("let cnt := mload(v)")
+			b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+			b.Indent()
+			b.P(fmt.Sprintf("let length := size_msg_%s(mload(add(v, mul(add(i, 1), 32))))", fieldTypeName))
+			b.P(fmt.Sprintf("n := add(n, add(%d, add(pb_size_varint(length), length)))", keySize))
+			b.Unindent()
+			b.P("}")
+
+		default:
+			x, err := toYulEncodedValue(field, "v")
+			if err != nil {
+				return err
+			}
+			if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_SINT32 ||
+				field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_SINT64 {
+				used["pb_zigzag"] = true
+			}
+
+			if !isFieldRepeated(field) {
+				b.P(fmt.Sprintf("let x := %s", x))
+				b.P("// Default value is omitted")
+				b.P("if x {")
+				b.P(fmt.Sprintf("    n := add(n, add(%d, %s))", keySize, toYulValueSize(field, "x")))
+				b.P("}")
+				break
+			}
+
+			b.P("let cnt := mload(v)")
+			b.P("// Empty packed array is omitted")
+			b.P("if cnt {")
+			b.Indent()
+			generateYulPackedLength(field, b)
+			b.P(fmt.Sprintf("n := add(n, add(%d, add(pb_size_varint(length), length)))", keySize))
+			b.Unindent()
+			b.P("}")
+		}
+
+		b.Unindent()
+		b.P("}")
+	}
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
+
+// generateYulPackedLength generates the Yul code that declares the length of
+// the payload of a packed repeated field, given its array v of cnt elements.
+func generateYulPackedLength(field *descriptorpb.FieldDescriptorProto, b *WriteableBuffer) {
+	if size := toPackedElementSize(field.GetType()); size > 0 {
+		b.P(fmt.Sprintf("let length := mul(cnt, %d)", size))
+		return
+	}
+
+	// Varints are the only other packed types
+	x, _ := toYulEncodedValue(field, "mload(add(v, mul(add(i, 1), 32)))")
+	b.P("let length := 0")
+	b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+	b.P(fmt.Sprintf("    length := add(length, pb_size_varint(%s))", x))
+	b.P("}")
+}
+
+// generateYulMessageEncoder generates the Yul function that encodes the
+// instance at ptr at p, returning the position after it.
+func (g *Generator) generateYulMessageEncoder(structName string, used map[string]bool, b *WriteableBuffer) error {
+	fields := g.messages[structName].GetField()
+
+	b.P(fmt.Sprintf("function encode_msg_%s(ptr, p) -> q {", structName))
+	b.Indent()
+	members := g.memberIndexes(fields)
+	for i, field := range fields {
+		key, err := toKey(field)
+		if err != nil {
+			return err
+		}
+
+		b.P(fmt.Sprintf("// Field %s", field.GetName()))
+		b.P("{")
+		b.Indent()
+		b.P(fmt.Sprintf("let v := mload(add(ptr, %d))", members[i]*32))
+
+		switch {
+		case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING ||
+			field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+			used["pb_copy"] = true
+
+			b.P("let length := mload(v)")
+			b.P("if length {")
+			b.Indent()
+			b.P(fmt.Sprintf("p := pb_write_varint(p, %d)", key))
+			b.P("p := pb_write_varint(p, length)")
+			b.P("pb_copy(add(v, 32), p, length)")
+			b.P("p := add(p, length)")
+			b.Unindent()
+			b.P("}")
+
+		case field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			fieldTypeName, err := toSolMessageOrEnumName(field)
+			if err != nil {
+				return err
+			}
+
+			if !isFieldRepeated(field) {
+				b.P(fmt.Sprintf("let length := size_msg_%s(v)", fieldTypeName))
+				b.P("if length {")
+				b.Indent()
+				b.P(fmt.Sprintf("p := pb_write_varint(p, %d)", key))
+				b.P("p := pb_write_varint(p, length)")
+				b.P(fmt.Sprintf("p := encode_msg_%s(v, p)", fieldTypeName))
+				b.Unindent()
+				b.P("}")
+				break
+			}
+
+			b.P("let cnt := mload(v)")
+			b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+			b.Indent()
+			b.P("let element := mload(add(v, mul(add(i, 1), 32)))")
+			b.P(fmt.Sprintf("p := pb_write_varint(p, %d)", key))
+			b.P(fmt.Sprintf("p := pb_write_varint(p, size_msg_%s(element))", fieldTypeName))
+			b.P(fmt.Sprintf("p := encode_msg_%s(element, p)", fieldTypeName))
+			b.Unindent()
+			b.P("}")
+
+		default:
+			x, err := toYulEncodedValue(field, "v")
+			if err != nil {
+				return err
+			}
+			if toPackedElementSize(field.GetType()) > 0 {
+				used["pb_write_fixed"] = true
+			}
+
+			if !isFieldRepeated(field) {
+				b.P(fmt.Sprintf("let x := %s", x))
+				b.P("// Default value is omitted")
+				b.P("if x {")
+				b.Indent()
+				b.P(fmt.Sprintf("p := pb_write_varint(p, %d)", key))
+				b.P(fmt.Sprintf("p := %s", toYulValueWrite(field, "x")))
+				b.Unindent()
+				b.P("}")
+				break
+			}
+
+			element, _ := toYulEncodedValue(field, "mload(add(v, mul(add(i, 1), 32)))")
+
+			b.P("let cnt := mload(v)")
+			b.P("// Empty packed array is omitted")
+			b.P("if cnt {")
+			b.Indent()
+			generateYulPackedLength(field, b)
+			b.P(fmt.Sprintf("p := pb_write_varint(p, %d)", key))
+			b.P("p := pb_write_varint(p, length)")
+			b.P("for { let i := 0 } lt(i, cnt) { i := add(i, 1) } {")
+			b.P(fmt.Sprintf("    p := %s", toYulValueWrite(field, element)))
+			b.P("}")
+			b.Unindent()
+			b.P("}")
+		}
+
+		b.Unindent()
+		b.P("}")
+	}
+	b.P("q := p")
+	b.Unindent()
+	b.P("}")
+	b.P()
+
+	return nil
+}
@@ -0,0 +1,159 @@
+const protobuf = require("protobufjs");
+
+const { assertFields } = require("./helpers");
+
+const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+const WideMessageProtoFile = "../test/pass/wide_message/wide_message.proto";
+
+// Tests of a fixture contract of codecs generated with other parameters, which
+// must accept the same inputs as protobufjs and decode them to the same values,
+// reject the same non-canonical inputs as the solidity backend, and encode
+// decoded messages back to the same bytes. The fixture has decode, decodeWide
+// and encode functions.
+module.exports = (Fixture) => {
+  describe("constructor", async () => {
+    it("should deploy", async () => {
+      await Fixture.deployed();
+    });
+  });
+
+  //////////////////////////////////////
+  // NOTICE
+  // Tests call functions twice, once to run and another to measure gas.
+  //////////////////////////////////////
+
+  describe("decode", async () => {
+    describe("passing", async () => {
+      it("all features", async () => {
+        const instance = await Fixture.deployed();
+
+        const root = await protobuf.load(AllFeaturesProtoFile);
+
+        const Message = root.lookupType("Message");
+        const messageObj = {
+          optionalInt32: -42,
+          optionalInt64: -420,
+          optionalUint32: 42,
+          optionalUint64: 420,
+          optionalSint32: -69,
+          optionalSint64: -690,
+          optionalFixed32: 900,
+          optionalFixed64: 9000,
+          optionalSfixed32: -900,
+          optionalSfixed64: -9000,
+          optionalBool: true,
+          optionalString: "foorbar",
+          optionalBytes: Buffer.from("deadbeef", "hex"),
+          optionalEnum: 1,
+          optionalMessage: { otherField: 3 },
+          repeatedInt32: ["-42", "-41"],
+          repeatedInt64: ["-420", "-421"],
+          repeatedUint32: ["42", "41"],
+          repeatedUint64: ["420", "419"],
+          repeatedSint32: ["-69", "-68"],
+          repeatedSint64: ["-690", "-689"],
+          repeatedFixed32: ["900", "899"],
+          repeatedFixed64: ["9000", "8999"],
+          repeatedSfixed32: ["-900", "-899"],
+          repeatedSfixed64: ["-9000", "-8999"],
+          repeatedBool: [true, false],
+          repeatedEnum: ["1", "2"],
+          repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+        };
+
+        const message = Message.create(messageObj);
+        const encoded = Message.encode(message).finish().toString("hex");
+
+        const result = await instance.decode.call("0x" + encoded);
+        assert.equal(result[0], true);
+        assertFields(result[1], messageObj);
+
+        // Encoding the decoded message must give back the same bytes
+        assert.equal(await instance.encode.call(result[1]), "0x" + encoded);
+
+        await instance.decode("0x" + encoded);
+      });
+
+      it("wide message", async () => {
+        const instance = await Fixture.deployed();
+
+        const root = await protobuf.load(WideMessageProtoFile);
+
+        const WideMessage = root.lookupType("WideMessage");
+        const messageObj = {};
+        for (let i = 1; i <= 40; i++) {
+          messageObj["f" + i] = i;
+        }
+
+        const message = WideMessage.create(messageObj);
+        const encoded = WideMessage.encode(message).finish().toString("hex");
+
+        const result = await instance.decodeWide.call("0x" + encoded);
+        assert.equal(result[0], true);
+        assertFields(result[1], messageObj);
+
+        await instance.decodeWide("0x" + encoded);
+      });
+    });
+
+    describe("failing", async () => {
+      const inputs = {
+        "fields out of order": "20011801",
+        "repeated not-repeated field": "20012001",
+        "included default value": "18002001",
+        "extra data": "18012001deadbeef",
+        "trailing zero in varint": "208000",
+        "int32 out of range": "088080808008",
+        "enum out of range": "7003",
+        "empty packed array": "820100",
+        "group wire type": "23",
+      };
+
+      for (const [name, encoded] of Object.entries(inputs)) {
+        it(name, async () => {
+          const instance = await Fixture.deployed();
+
+          const result = await instance.decode.call("0x" + encoded);
+          assert.equal(result[0], false);
+        });
+      }
+    });
+  });
+
+  describe("encode", async () => {
+    it("round trip", async () => {
+      const instance = await Fixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalSint64: -690,
+        optionalFixed32: 900,
+        optionalSfixed64: -9000,
+        optionalBool: true,
+        optionalString: "foorbar",
+        optionalBytes: Buffer.from("deadbeef", "hex"),
+        optionalEnum: 2,
+        optionalMessage: { otherField: 3 },
+        repeatedInt32: ["-42", "-41"],
+        repeatedSint32: ["-69", "-68"],
+        repeatedFixed64: ["9000", "8999"],
+        repeatedBool: [true, false],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+
+      const message = Message.create(messageObj);
+      const encoded = Message.encode(message).finish().toString("hex");
+
+      const decoded = await instance.decode.call("0x" + encoded);
+      assert.equal(decoded[0], true);
+
+      const result = await instance.encode.call(decoded[1]);
+      assert.equal(result, "0x" + encoded);
+
+      await instance.encode(decoded[1]);
+    });
+  });
+};
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: Apache-2.0
+pragma solidity >=0.6.9 <8.0.0;
+pragma experimental ABIEncoderV2;
+
+import "./all_features.proto.sol";
+import "./recursive.proto.sol";
+
+// Encoders of the messages of TestFixture, which decodes the messages to encode
+contract EncoderTestFixture {
+    // Functions are not pure so that we can measure gas
+
+    function encode(Message memory instance) public returns (bytes memory) {
+        return MessageCodec.encode(instance);
+    }
+
+    function encodeTo(
+        Message memory instance,
+        bytes memory buf,
+        uint256 offset
+    ) public returns (bytes memory, uint256) {
+        uint256 end = MessageCodec.encode_to(instance, buf, offset);
+
+        return (buf, end);
+    }
+
+    function encodeStream(Message[] memory instances) public returns (bytes memory) {
+        return MessageCodec.encode_delimited_stream(instances);
+    }
+
+    function encodeNode(bytes memory buf) public returns (bool, bytes memory) {
+        // Recursive structs can't be passed, so decode the message to encode
+        (bool success, uint64 pos, Node memory instance) = NodeCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, NodeCodec.encode(instance));
+    }
+
+    function hash(Message memory instance) public returns (bytes32, bytes32) {
+        return (MessageCodec.hash(instance), MessageCodec.hash_sha256(instance));
+    }
+
+    function merkleRoot(Message memory instance) public returns (bytes32) {
+        return MessageCodec.merkle_root(instance);
+    }
+
+    function verifyFieldProof(
+        bytes32 root,
+        uint64 field_number,
+        bytes memory leaf,
+        bytes32[] memory aunts
+    ) public returns (bool) {
+        return MessageCodec.verify_field_proof(root, field_number, leaf, aunts);
+    }
+}
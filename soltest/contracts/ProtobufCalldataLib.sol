@@ -0,0 +1 @@
+../../test/pass/all_features/ProtobufCalldataLib.sol
\ No newline at end of file
@@ -0,0 +1 @@
+../../test/pass/all_features/ProtobufSupportLib.sol
\ No newline at end of file
@@ -1,10 +1,15 @@
 // SPDX-License-Identifier: Apache-2.0
-pragma solidity >=0.6.0 <8.0.0;
+pragma solidity >=0.6.9 <8.0.0;
 pragma experimental ABIEncoderV2;
 
 import "@lazyledger/protobuf3-solidity-lib/contracts/ProtobufLib.sol";
 import "./all_features.proto.sol";
+import "./empty_message.proto.sol";
+import "./limits.proto.sol";
+import "./recursive.proto.sol";
 import "./top.proto.sol";
+import "./well_known.proto.sol";
+import "./wide_message.proto.sol";
 
 contract TestFixture {
     // Functions are not pure so that we can measure gas
@@ -15,7 +20,179 @@ contract TestFixture {
         return (success, instance);
     }
 
-    // function encode(Message memory instance) public returns (bytes memory) {
-    //     return MessageCodec.encode(instance);
-    // }
+    function decodeCalldata(bytes calldata buf) external returns (bool, Message memory) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode_calldata(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function decodeWide(bytes memory buf) public returns (bool, WideMessage memory) {
+        (bool success, uint64 pos, WideMessage memory instance) = WideMessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function decodeStream(bytes memory buf) public returns (bool, Message[] memory) {
+        return MessageCodec.decode_delimited_stream(buf);
+    }
+
+    function decodePartial(bytes memory buf, uint256 mask) public returns (bool, Message memory) {
+        return MessageCodec.decode_partial(buf, mask);
+    }
+
+    function partialMask() public pure returns (uint256) {
+        return MessageCodec.MASK_OPTIONAL_INT32 | MessageCodec.MASK_OPTIONAL_STRING | MessageCodec.MASK_REPEATED_MESSAGE;
+    }
+
+    function hashEncoded(bytes memory buf) public returns (bool, bytes32, bytes32) {
+        (bool success, bytes32 h) = MessageCodec.hash_encoded(buf);
+        (, bytes32 h_sha256) = MessageCodec.hash_encoded_sha256(buf);
+
+        return (success, h, h_sha256);
+    }
+
+    function verifyOptionalString(
+        bytes memory buf,
+        uint64 offset,
+        string memory value
+    ) public returns (bool) {
+        return MessageCodec.verify_optional_string(buf, offset, value);
+    }
+
+    function verifyOptionalMessageOtherField(
+        bytes memory buf,
+        uint64 offset,
+        uint64 value
+    ) public returns (bool) {
+        return MessageCodec.verify_optional_message__other_field(buf, offset, value);
+    }
+
+    function getOptionalInt32(bytes memory buf) public returns (bool, int32) {
+        return MessageCodec.get_optional_int32(buf);
+    }
+
+    function getOptionalString(bytes memory buf) public returns (bool, string memory) {
+        return MessageCodec.get_optional_string(buf);
+    }
+
+    function getOptionalMessageOtherField(bytes memory buf) public returns (bool, uint64) {
+        return MessageCodec.get_optional_message__other_field(buf);
+    }
+
+    function iterRepeatedSint32(bytes memory buf) public returns (bool, int64, uint256) {
+        (bool success, MessageCodec.Cursor memory cursor) = MessageCodec.iter_repeated_sint32(buf);
+        int64 sum = 0;
+        uint256 count = 0;
+        while (success) {
+            bool found;
+            int32 v;
+            (success, found, v) = MessageCodec.next_repeated_sint32(cursor);
+            if (!found) {
+                break;
+            }
+            sum += v;
+            count++;
+        }
+
+        return (success, sum, count);
+    }
+
+    function iterRepeatedMessage(bytes memory buf) public returns (bool, uint64, uint256) {
+        (bool success, MessageCodec.Cursor memory cursor) = MessageCodec.iter_repeated_message(buf);
+        uint64 sum = 0;
+        uint256 count = 0;
+        while (success) {
+            bool found;
+            OtherMessage memory v;
+            (success, found, v) = MessageCodec.next_repeated_message(cursor);
+            if (!found) {
+                break;
+            }
+            sum += v.other_field;
+            count++;
+        }
+
+        return (success, sum, count);
+    }
+
+    function typedDataHash(OtherMessage memory instance) public view returns (bytes32, bytes32) {
+        uint256 chain_id;
+        assembly {
+            chain_id := chainid()
+        }
+        bytes32 separator = ProtobufSupportLib.domain_separator("TestFixture", "1", chain_id, address(this));
+
+        return (OtherMessageCodec.hash_struct(instance), OtherMessageCodec.typed_data_hash(instance, separator));
+    }
+
+    function schema() public pure returns (bytes32, string memory) {
+        return (OtherMessageCodec.SCHEMA_HASH, OtherMessageCodec.TYPE_URL);
+    }
+
+    function decodeNode(bytes memory buf) public returns (bool, uint64, uint256) {
+        (bool success, uint64 pos, Node memory instance) = NodeCodec.decode(0, buf, uint64(buf.length));
+
+        // Recursive structs can't be returned, so return the value and number of children of the first child
+        if (!success || instance.children.length == 0) {
+            return (success, instance.value, 0);
+        }
+        return (success, instance.children[0].value, instance.children[0].children.length);
+    }
+
+    function nodeSchema() public pure returns (bytes32) {
+        return NodeCodec.SCHEMA_HASH;
+    }
+
+    function decodeAny(
+        string memory type_url,
+        bytes memory value,
+        bool strict
+    ) public returns (bool, AllFeaturesRegistry.Kind, uint64) {
+        (bool success, AllFeaturesRegistry.Unpacked memory unpacked) = AllFeaturesRegistry.decode_any(type_url, value, strict);
+
+        return (success, unpacked.kind, unpacked.other_message.other_field);
+    }
+
+    function decodeLimits(bytes memory buf) public returns (bool, LimitsMessage memory) {
+        (bool success, uint64 pos, LimitsMessage memory instance) = LimitsMessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function decodeEmpty(bytes memory buf) public returns (bool, EmptyContainer memory) {
+        (bool success, uint64 pos, EmptyContainer memory instance) = EmptyContainerCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function decodeEmptyMessage(bytes memory buf) public returns (bool) {
+        (bool success, uint64 pos, EmptyMessage memory instance) = EmptyMessageCodec.decode(0, buf, uint64(buf.length));
+
+        return success;
+    }
+
+    function decodeSchedule(bytes memory buf) public returns (bool, Schedule memory) {
+        (bool success, uint64 pos, Schedule memory instance) = ScheduleCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function timestamp(int64 unix_seconds) public pure returns (bool, int64) {
+        Timestamp memory instance = TimestampCodec.from_unix_seconds(unix_seconds);
+
+        return (TimestampCodec.is_valid(instance), TimestampCodec.to_unix_seconds(instance));
+    }
+
+    Message stored;
+
+    function decodeAndStore(bytes memory buf) public returns (bool) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+        MessageCodec.store(stored, instance);
+
+        return success;
+    }
+
+    function load() public view returns (Message memory) {
+        return MessageCodec.load(stored);
+    }
 }
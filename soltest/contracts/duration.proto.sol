@@ -0,0 +1 @@
+../../test/pass/well_known/duration.proto.sol
\ No newline at end of file
@@ -0,0 +1 @@
+../../test/pass/empty_message/empty_message.proto.sol
\ No newline at end of file
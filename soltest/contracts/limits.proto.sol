@@ -0,0 +1 @@
+../../test/pass/limits/limits.proto.sol
\ No newline at end of file
@@ -0,0 +1 @@
+../../test/pass/recursive/recursive.proto.sol
\ No newline at end of file
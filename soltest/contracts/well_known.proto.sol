@@ -0,0 +1 @@
+../../test/pass/well_known/well_known.proto.sol
\ No newline at end of file
@@ -0,0 +1 @@
+../../test/pass/wide_message/wide_message.proto.sol
\ No newline at end of file
@@ -0,0 +1 @@
+../../test/pass/well_known/wrappers.proto.sol
\ No newline at end of file
@@ -0,0 +1,60 @@
+const assert = require("assert");
+const crypto = require("crypto");
+
+// Merkle tree of CometBFT's crypto/merkle package
+const sha256 = (...bufs) => crypto.createHash("sha256").update(Buffer.concat(bufs)).digest();
+const leafHash = (leaf) => sha256(Buffer.from([0]), leaf);
+const innerHash = (left, right) => sha256(Buffer.from([1]), left, right);
+const splitPoint = (n) => {
+  let k = 1;
+  while (k * 2 < n) {
+    k *= 2;
+  }
+  return k;
+};
+const merkleRoot = (hashes) => {
+  if (hashes.length === 1) {
+    return hashes[0];
+  }
+  const k = splitPoint(hashes.length);
+  return innerHash(merkleRoot(hashes.slice(0, k)), merkleRoot(hashes.slice(k)));
+};
+// Aunts of a leaf, from the bottom up
+const merkleAunts = (hashes, index) => {
+  if (hashes.length === 1) {
+    return [];
+  }
+  const k = splitPoint(hashes.length);
+  if (index < k) {
+    return [...merkleAunts(hashes.slice(0, k), index), merkleRoot(hashes.slice(k))];
+  }
+  return [...merkleAunts(hashes.slice(k), index - k), merkleRoot(hashes.slice(0, k))];
+};
+
+// Name of a struct member, from the camel case name of a protobufjs field
+const toMemberName = (name) => name.replace(/[A-Z]/g, (c) => "_" + c.toLowerCase());
+
+// Assert that a struct returned by a contract has the values of the fields of
+// a protobufjs object. Members are looked up by name, so that the comparison
+// doesn't depend on the layout of the struct.
+const assertFields = (result, expected, path = "") => {
+  for (const [name, value] of Object.entries(expected)) {
+    assertValue(result[toMemberName(name)], value, path + toMemberName(name));
+  }
+};
+const assertValue = (actual, expected, path) => {
+  if (Buffer.isBuffer(expected)) {
+    assert.strictEqual(actual, "0x" + expected.toString("hex"), path);
+  } else if (Array.isArray(expected)) {
+    assert.strictEqual(actual.length, expected.length, path);
+    expected.forEach((value, i) => assertValue(actual[i], value, path + "[" + i + "]"));
+  } else if (typeof expected === "object") {
+    assertFields(actual, expected, path + ".");
+  } else if (typeof expected === "boolean") {
+    assert.strictEqual(actual, expected, path);
+  } else {
+    assert.strictEqual(actual.toString(), expected.toString(), path);
+  }
+};
+
+module.exports = { leafHash, merkleRoot, merkleAunts, assertFields };
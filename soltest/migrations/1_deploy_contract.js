@@ -1,5 +1,7 @@
 const TestFixture = artifacts.require("TestFixture");
+const EncoderTestFixture = artifacts.require("EncoderTestFixture");
 
 module.exports = function (deployer) {
   deployer.deploy(TestFixture);
+  deployer.deploy(EncoderTestFixture);
 };
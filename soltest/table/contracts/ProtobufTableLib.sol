@@ -0,0 +1 @@
+../../../test/pass/all_features/table/ProtobufTableLib.sol
\ No newline at end of file
@@ -0,0 +1,5 @@
+const TableTestFixture = artifacts.require("TableTestFixture");
+
+module.exports = function (deployer) {
+  deployer.deploy(TableTestFixture);
+};
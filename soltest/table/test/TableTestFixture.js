@@ -0,0 +1,7 @@
+const testCodec = require("../../codec-tests");
+
+const TableTestFixture = artifacts.require("TableTestFixture");
+
+contract("TableTestFixture", async (accounts) => {
+  testCodec(TableTestFixture);
+});
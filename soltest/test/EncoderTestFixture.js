@@ -0,0 +1,231 @@
+const crypto = require("crypto");
+const protobuf = require("protobufjs");
+const truffleAssert = require("truffle-assertions");
+
+const { leafHash, merkleRoot, merkleAunts } = require("../helpers");
+
+const TestFixture = artifacts.require("TestFixture");
+const EncoderTestFixture = artifacts.require("EncoderTestFixture");
+
+const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+const RecursiveProtoFile = "../test/pass/recursive/recursive.proto";
+
+// Encode a message with protobufjs, and decode it with TestFixture to get the
+// struct to encode
+const prepare = async (messageObj) => {
+  const root = await protobuf.load(AllFeaturesProtoFile);
+  const Message = root.lookupType("Message");
+  const encoded = Message.encode(Message.create(messageObj)).finish();
+
+  const decoder = await TestFixture.deployed();
+  const decoded = await decoder.decode.call("0x" + encoded.toString("hex"));
+  assert.equal(decoded[0], true);
+
+  return [encoded, decoded[1]];
+};
+
+contract("EncoderTestFixture", async (accounts) => {
+  describe("constructor", async () => {
+    it("should deploy", async () => {
+      await EncoderTestFixture.deployed();
+    });
+  });
+
+  //////////////////////////////////////
+  // NOTICE
+  // Tests call functions twice, once to run and another to measure gas.
+  //////////////////////////////////////
+
+  describe("encode", async () => {
+    const inputs = {
+      "all features": {
+        optionalInt32: -42,
+        optionalInt64: -420,
+        optionalUint32: 42,
+        optionalUint64: 420,
+        optionalSint32: -69,
+        optionalSint64: -690,
+        optionalFixed32: 900,
+        optionalFixed64: 9000,
+        optionalSfixed32: -900,
+        optionalSfixed64: -9000,
+        optionalBool: true,
+        optionalString: "foorbar",
+        optionalBytes: Buffer.from("deadbeef", "hex"),
+        optionalEnum: 1,
+        optionalMessage: { otherField: 3 },
+        repeatedInt32: ["-42", "-41"],
+        repeatedInt64: ["-420", "-421"],
+        repeatedUint32: ["42", "41"],
+        repeatedUint64: ["420", "419"],
+        repeatedSint32: ["-69", "-68"],
+        repeatedSint64: ["-690", "-689"],
+        repeatedFixed32: ["900", "899"],
+        repeatedFixed64: ["9000", "8999"],
+        repeatedSfixed32: ["-900", "-899"],
+        repeatedSfixed64: ["-9000", "-8999"],
+        repeatedBool: [true, false],
+        repeatedEnum: ["1", "2"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      },
+      "nested message": {
+        optionalInt32: -42,
+        optionalMessage: { otherField: 300 },
+      },
+      "packed fields": {
+        repeatedInt32: ["-1", "0", "2147483647"],
+        repeatedUint64: ["0", "127", "128", "18446744073709551615"],
+        repeatedSint64: ["-1", "1"],
+        repeatedFixed32: ["1"],
+        repeatedSfixed64: ["-9000", "9000"],
+        repeatedBool: [false, true, true],
+        repeatedEnum: ["0", "2"],
+      },
+      "repeated messages": {
+        repeatedMessage: [{ otherField: 1 }, { otherField: 300 }, { otherField: "18446744073709551615" }],
+      },
+    };
+
+    for (const [name, messageObj] of Object.entries(inputs)) {
+      it(name, async () => {
+        const instance = await EncoderTestFixture.deployed();
+
+        const [encoded, decoded] = await prepare(messageObj);
+
+        const result = await instance.encode.call(decoded);
+        assert.equal(result, "0x" + encoded.toString("hex"));
+
+        await instance.encode(decoded);
+      });
+    }
+
+    it("deeply nested messages", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const root = await protobuf.load(RecursiveProtoFile);
+
+      const Node = root.lookupType("Node");
+      let nodeObj = { value: 8 };
+      for (let i = 7; i > 0; i--) {
+        nodeObj = { value: i, children: [nodeObj, { value: 100 + i }] };
+      }
+      const encoded = Node.encode(Node.create(nodeObj)).finish().toString("hex");
+
+      const result = await instance.encodeNode.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], "0x" + encoded);
+
+      await instance.encodeNode("0x" + encoded);
+    });
+  });
+
+  describe("encode to", async () => {
+    it("at offset", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const [encoded, decoded] = await prepare({
+        optionalString: "foorbar",
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      });
+
+      // The bytes around the encoding are left untouched
+      const buf = Buffer.concat([Buffer.from("aabbcc", "hex"), Buffer.alloc(encoded.length), Buffer.from("dd", "hex")]);
+      const expected = Buffer.concat([Buffer.from("aabbcc", "hex"), encoded, Buffer.from("dd", "hex")]);
+
+      const result = await instance.encodeTo.call(decoded, "0x" + buf.toString("hex"), 3);
+      assert.equal(result[0], "0x" + expected.toString("hex"));
+      assert.equal(result[1], 3 + encoded.length);
+
+      await instance.encodeTo(decoded, "0x" + buf.toString("hex"), 3);
+    });
+
+    it("buffer too small", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const [encoded, decoded] = await prepare({ optionalString: "foorbar" });
+
+      const buf = "0x" + Buffer.alloc(encoded.length + 2).toString("hex");
+      await truffleAssert.reverts(instance.encodeTo.call(decoded, buf, 3), "Message: buffer too small");
+      await truffleAssert.reverts(instance.encodeTo.call(decoded, buf, encoded.length + 3), "Message: buffer too small");
+    });
+  });
+
+  describe("delimited stream", async () => {
+    it("messages prefixed by their length", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObjs = [
+        { optionalInt32: -42, repeatedMessage: [{ otherField: 1 }] },
+        { optionalString: "foorbar", repeatedSint32: ["-69", "-68"] },
+      ];
+
+      const decoded = [];
+      const writer = protobuf.Writer.create();
+      for (const messageObj of messageObjs) {
+        Message.encodeDelimited(Message.create(messageObj), writer);
+        decoded.push((await prepare(messageObj))[1]);
+      }
+      const encoded = writer.finish().toString("hex");
+
+      const result = await instance.encodeStream.call(decoded);
+      assert.equal(result, "0x" + encoded);
+
+      await instance.encodeStream(decoded);
+    });
+  });
+
+  describe("hash", async () => {
+    it("of the encoding", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const [encoded, decoded] = await prepare({
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        optionalMessage: { otherField: 3 },
+        repeatedSint32: ["-69", "-68"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      });
+
+      const result = await instance.hash.call(decoded);
+      assert.equal(result[0], web3.utils.keccak256("0x" + encoded.toString("hex")));
+      assert.equal(result[1], "0x" + crypto.createHash("sha256").update(encoded).digest("hex"));
+
+      await instance.hash(decoded);
+    });
+  });
+
+  describe("merkle", async () => {
+    it("root and field proof", async () => {
+      const instance = await EncoderTestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const OtherMessage = root.lookupType("OtherMessage");
+
+      // optional_int32 is field 1, so its leaf is the encoding of a Message
+      // with only that field, and the leaf of optional_message (field 15) is
+      // the encoding of the embedded message. Default fields have empty leaves.
+      const leaves = new Array(28).fill(Buffer.alloc(0));
+      leaves[0] = Buffer.from(Message.encode(Message.create({ optionalInt32: -42 })).finish());
+      leaves[14] = Buffer.from(OtherMessage.encode(OtherMessage.create({ otherField: 3 })).finish());
+      const hashes = leaves.map(leafHash);
+      const expected = "0x" + merkleRoot(hashes).toString("hex");
+
+      const [, decoded] = await prepare({ optionalInt32: -42, optionalMessage: { otherField: 3 } });
+
+      const result = await instance.merkleRoot.call(decoded);
+      assert.equal(result, expected);
+
+      const aunts = merkleAunts(hashes, 14).map((h) => "0x" + h.toString("hex"));
+      const leaf = "0x" + leaves[14].toString("hex");
+      assert.equal(await instance.verifyFieldProof.call(expected, 15, leaf, aunts), true);
+      assert.equal(await instance.verifyFieldProof.call(expected, 14, leaf, aunts), false);
+
+      await instance.merkleRoot(decoded);
+    });
+  });
+});
@@ -1,9 +1,15 @@
+const crypto = require("crypto");
 const protobuf = require("protobufjs");
 const truffleAssert = require("truffle-assertions");
 
 const TestFixture = artifacts.require("TestFixture");
 
 const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+const RecursiveProtoFile = "../test/pass/recursive/recursive.proto";
+const EmptyMessageProtoFile = "../test/pass/empty_message/empty_message.proto";
+const LimitsProtoFile = "../test/pass/limits/limits.proto";
+const WideMessageProtoFile = "../test/pass/wide_message/wide_message.proto";
+const WellKnownProtoFile = "../test/pass/well_known/well_known.proto";
 
 contract("TestFixture", async (accounts) => {
   describe("constructor", async () => {
@@ -124,6 +130,107 @@ contract("TestFixture", async (accounts) => {
       });
     });
 
+    describe("wide message", async () => {
+      // Gas used by these tests measures the cost of dispatching on field
+      // numbers, which grows with the number of fields in a message
+
+      it("all fields", async () => {
+        const instance = await TestFixture.deployed();
+
+        const root = await protobuf.load(WideMessageProtoFile);
+
+        const WideMessage = root.lookupType("WideMessage");
+        const messageObj = {};
+        for (let i = 1; i <= 40; i++) {
+          messageObj["f" + i] = i;
+        }
+
+        const message = WideMessage.create(messageObj);
+        const encoded = WideMessage.encode(message).finish().toString("hex");
+
+        const result = await instance.decodeWide.call("0x" + encoded);
+        const { 0: success, 1: decoded } = result;
+        assert.equal(success, true);
+        for (let i = 1; i <= 40; i++) {
+          assert.equal(decoded["f" + i], messageObj["f" + i]);
+        }
+
+        await instance.decodeWide("0x" + encoded);
+      });
+
+      it("last field", async () => {
+        const instance = await TestFixture.deployed();
+
+        const root = await protobuf.load(WideMessageProtoFile);
+
+        const WideMessage = root.lookupType("WideMessage");
+        const messageObj = {
+          f40: 40,
+        };
+
+        const message = WideMessage.create(messageObj);
+        const encoded = WideMessage.encode(message).finish().toString("hex");
+
+        const result = await instance.decodeWide.call("0x" + encoded);
+        const { 0: success, 1: decoded } = result;
+        assert.equal(success, true);
+        assert.equal(decoded.f40, messageObj.f40);
+
+        await instance.decodeWide("0x" + encoded);
+      });
+
+      it("wrong wire type", async () => {
+        const instance = await TestFixture.deployed();
+
+        // Field 40 with wire type Bits64 instead of Varint
+        const encoded = "c1022800000000000000";
+
+        const result = await instance.decodeWide.call("0x" + encoded);
+        const { 0: success, 1: decoded } = result;
+        assert.equal(success, false);
+      });
+    });
+
+    describe("calldata", async () => {
+      it("matches memory decoding", async () => {
+        const instance = await TestFixture.deployed();
+
+        const root = await protobuf.load(AllFeaturesProtoFile);
+
+        const Message = root.lookupType("Message");
+        const messageObj = {
+          optionalInt32: -42,
+          optionalSint64: -690,
+          optionalFixed32: 900,
+          optionalString: "foorbar",
+          optionalBytes: Buffer.from("deadbeef", "hex"),
+          optionalMessage: { otherField: 3 },
+          repeatedUint64: ["420", "419"],
+          repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+        };
+
+        const message = Message.create(messageObj);
+        const encoded = Message.encode(message).finish().toString("hex");
+
+        const expected = await instance.decode.call("0x" + encoded);
+        const result = await instance.decodeCalldata.call("0x" + encoded);
+        assert.equal(result[0], true);
+        assert.deepStrictEqual(result[1], expected[1]);
+
+        await instance.decodeCalldata("0x" + encoded);
+      });
+
+      it("extra data", async () => {
+        const instance = await TestFixture.deployed();
+
+        const encoded = "18012001deadbeef";
+
+        const result = await instance.decodeCalldata.call("0x" + encoded);
+        const { 0: success, 1: decoded } = result;
+        assert.equal(success, false);
+      });
+    });
+
     describe("failing", async () => {
       it("fields out of order", async () => {
         const instance = await TestFixture.deployed();
@@ -186,5 +293,645 @@ contract("TestFixture", async (accounts) => {
     });
   });
 
-  describe("encode", async () => {});
+  describe("delimited stream", async () => {
+    it("decodes all messages", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObjs = [{ optionalUint64: 420 }, {}, { optionalString: "foorbar", repeatedUint32: ["42", "41"] }];
+
+      const writer = protobuf.Writer.create();
+      for (const messageObj of messageObjs) {
+        Message.encodeDelimited(Message.create(messageObj), writer);
+      }
+      const encoded = writer.finish().toString("hex");
+
+      const result = await instance.decodeStream.call("0x" + encoded);
+      const { 0: success, 1: decoded } = result;
+      assert.equal(success, true);
+      assert.equal(decoded.length, messageObjs.length);
+      assert.equal(decoded[0].optional_uint64, messageObjs[0].optionalUint64);
+      assert.equal(decoded[2].optional_string, messageObjs[2].optionalString);
+
+      await instance.decodeStream("0x" + encoded);
+    });
+
+    it("truncated message", async () => {
+      const instance = await TestFixture.deployed();
+
+      // Length prefix of 3 bytes, followed by only 2 bytes
+      const encoded = "032001";
+
+      const result = await instance.decodeStream.call("0x" + encoded);
+      const { 0: success, 1: decoded } = result;
+      assert.equal(success, false);
+    });
+  });
+
+  describe("partial decode", async () => {
+    it("selected fields", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalUint64: 420,
+        optionalString: "foorbar",
+        optionalBytes: Buffer.from("deadbeef", "hex"),
+        optionalMessage: { otherField: 3 },
+        repeatedSint64: ["-690", "-689"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+      const selectedObj = {
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+
+      const encoded = Message.encode(Message.create(messageObj)).finish().toString("hex");
+      const selected = Message.encode(Message.create(selectedObj)).finish().toString("hex");
+
+      const mask = await instance.partialMask.call();
+      const expected = await instance.decode.call("0x" + selected);
+      const result = await instance.decodePartial.call("0x" + encoded, mask);
+      assert.equal(result[0], true);
+      assert.deepStrictEqual(result[1], expected[1]);
+
+      await instance.decodePartial("0x" + encoded, mask);
+    });
+
+    it("skipped fields out of order", async () => {
+      const instance = await TestFixture.deployed();
+
+      const mask = await instance.partialMask.call();
+      const result = await instance.decodePartial.call("0x20011801", mask);
+      assert.equal(result[0], false);
+    });
+
+    it("skipped field with wrong wire type", async () => {
+      const instance = await TestFixture.deployed();
+
+      const mask = await instance.partialMask.call();
+      const result = await instance.decodePartial.call("0x210100000000000000", mask);
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("hash", async () => {
+    it("canonical encoding", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+
+      const encoded = Message.encode(Message.create(messageObj)).finish();
+
+      const result = await instance.hashEncoded.call("0x" + encoded.toString("hex"));
+      assert.equal(result[0], true);
+      assert.equal(result[1], web3.utils.keccak256("0x" + encoded.toString("hex")));
+      assert.equal(result[2], "0x" + crypto.createHash("sha256").update(encoded).digest("hex"));
+
+      await instance.hashEncoded("0x" + encoded.toString("hex"));
+    });
+
+    it("non-canonical encoding", async () => {
+      const instance = await TestFixture.deployed();
+
+      const result = await instance.hashEncoded.call("0x20011801");
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("accessors", async () => {
+    it("field values", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        optionalMessage: { otherField: 3 },
+        repeatedSint32: ["-69", "-68"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+      const encoded = "0x" + Message.encode(Message.create(messageObj)).finish().toString("hex");
+
+      let result = await instance.getOptionalInt32.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], -42);
+
+      result = await instance.getOptionalString.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], "foorbar");
+
+      result = await instance.getOptionalMessageOtherField.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 3);
+
+      await instance.getOptionalString(encoded);
+      await instance.getOptionalMessageOtherField(encoded);
+    });
+
+    it("absent fields", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const encoded = "0x" + Message.encode(Message.create({ optionalInt32: -42 })).finish().toString("hex");
+
+      let result = await instance.getOptionalString.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], "");
+
+      result = await instance.getOptionalMessageOtherField.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 0);
+    });
+
+    it("fields out of order", async () => {
+      const instance = await TestFixture.deployed();
+
+      // optional_uint64 (field 4) followed by optional_uint32 (field 3), before optional_string
+      const result = await instance.getOptionalString.call("0x20011801");
+      assert.equal(result[0], false);
+    });
+
+    it("nested default value", async () => {
+      const instance = await TestFixture.deployed();
+
+      // optional_message with other_field included with the default value
+      const result = await instance.getOptionalMessageOtherField.call("0x7a020800");
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("iterators", async () => {
+    it("packed field", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        repeatedSint32: ["-69", "-68", "5"],
+        repeatedMessage: [{ otherField: 1 }],
+      };
+      const encoded = "0x" + Message.encode(Message.create(messageObj)).finish().toString("hex");
+
+      const result = await instance.iterRepeatedSint32.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], -132);
+      assert.equal(result[2], 3);
+
+      await instance.iterRepeatedSint32(encoded);
+    });
+
+    it("repeated message field", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        repeatedSint32: ["-69", "-68"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }, { otherField: 3 }],
+      };
+      const encoded = "0x" + Message.encode(Message.create(messageObj)).finish().toString("hex");
+
+      const result = await instance.iterRepeatedMessage.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 6);
+      assert.equal(result[2], 3);
+
+      await instance.iterRepeatedMessage(encoded);
+    });
+
+    it("absent fields", async () => {
+      const instance = await TestFixture.deployed();
+
+      // Only optional_int32
+      let result = await instance.iterRepeatedSint32.call("0x08d6ffffffffffffffff01");
+      assert.equal(result[0], true);
+      assert.equal(result[2], 0);
+
+      result = await instance.iterRepeatedMessage.call("0x08d6ffffffffffffffff01");
+      assert.equal(result[0], true);
+      assert.equal(result[2], 0);
+    });
+
+    it("invalid elements", async () => {
+      const instance = await TestFixture.deployed();
+
+      // repeated_sint32 with a trailing zero in a varint
+      let result = await instance.iterRepeatedSint32.call("0xa201028000");
+      assert.equal(result[0], false);
+
+      // repeated_message with other_field included with the default value
+      result = await instance.iterRepeatedMessage.call("0xe201020800");
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("verify field", async () => {
+    const prefixObj = {
+      optionalInt32: -42,
+      optionalString: "foorbar",
+    };
+    const messageObj = {
+      ...prefixObj,
+      optionalMessage: { otherField: 3 },
+      repeatedSint32: ["-69", "-68"],
+    };
+
+    it("field value at offset", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const encoded = "0x" + Message.encode(Message.create(messageObj)).finish().toString("hex");
+
+      // optional_string follows optional_int32
+      const offset = Message.encode(Message.create({ optionalInt32: -42 })).finish().length;
+      assert.equal(await instance.verifyOptionalString.call(encoded, offset, "foorbar"), true);
+      assert.equal(await instance.verifyOptionalString.call(encoded, offset, "foobar"), false);
+      assert.equal(await instance.verifyOptionalString.call(encoded, offset + 1, "foorbar"), false);
+
+      // optional_message.other_field follows the key and length of optional_message
+      const nestedOffset = Message.encode(Message.create(prefixObj)).finish().length + 2;
+      assert.equal(await instance.verifyOptionalMessageOtherField.call(encoded, nestedOffset, 3), true);
+      assert.equal(await instance.verifyOptionalMessageOtherField.call(encoded, nestedOffset, 4), false);
+
+      await instance.verifyOptionalString(encoded, offset, "foorbar");
+    });
+
+    it("fields out of order", async () => {
+      const instance = await TestFixture.deployed();
+
+      // optional_string (field 12) followed by optional_int32 (field 1)
+      const encoded = "0x6203666f6f082a";
+      assert.equal(await instance.verifyOptionalString.call(encoded, 0, "foo"), false);
+    });
+  });
+
+  describe("eip712", async () => {
+    it("typed data hash", async () => {
+      const instance = await TestFixture.deployed();
+
+      const abi = web3.eth.abi;
+      const keccak256 = web3.utils.keccak256;
+
+      const typeHash = keccak256(web3.utils.asciiToHex("OtherMessage(uint64 other_field)"));
+      const structHash = keccak256(abi.encodeParameters(["bytes32", "uint64"], [typeHash, 3]));
+
+      const domainTypeHash = keccak256(
+        web3.utils.asciiToHex("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)")
+      );
+      const chainId = await web3.eth.getChainId();
+      const separator = keccak256(
+        abi.encodeParameters(
+          ["bytes32", "bytes32", "bytes32", "uint256", "address"],
+          [
+            domainTypeHash,
+            keccak256(web3.utils.asciiToHex("TestFixture")),
+            keccak256(web3.utils.asciiToHex("1")),
+            chainId,
+            instance.address,
+          ]
+        )
+      );
+      const digest = keccak256("0x1901" + separator.slice(2) + structHash.slice(2));
+
+      const result = await instance.typedDataHash.call({ other_field: 3 });
+      assert.equal(result[0], structHash);
+      assert.equal(result[1], digest);
+    });
+  });
+
+  describe("schema", async () => {
+    it("schema hash and type URL", async () => {
+      const instance = await TestFixture.deployed();
+
+      const descriptor = "protobuf3-solidity/schema/v1\n1 singular uint64\n";
+      const expected = "0x" + crypto.createHash("sha256").update(descriptor).digest("hex");
+
+      const result = await instance.schema.call();
+      assert.equal(result[0], expected);
+      assert.equal(result[1], "type.googleapis.com/OtherMessage");
+    });
+
+    it("schema hash of a recursive message", async () => {
+      const instance = await TestFixture.deployed();
+
+      const descriptor = "protobuf3-solidity/schema/v1\n1 singular uint64\n2 repeated message:back:0\n";
+      const expected = "0x" + crypto.createHash("sha256").update(descriptor).digest("hex");
+
+      assert.equal(await instance.nodeSchema.call(), expected);
+    });
+  });
+
+  describe("registry", async () => {
+    it("decodes by type URL", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const OtherMessage = root.lookupType("OtherMessage");
+      const message = OtherMessage.create({ otherField: 7 });
+      const encoded = OtherMessage.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeAny.call("type.googleapis.com/OtherMessage", "0x" + encoded, true);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 1);
+      assert.equal(result[2], 7);
+      await instance.decodeAny("type.googleapis.com/OtherMessage", "0x" + encoded, true);
+    });
+
+    it("unknown type URL", async () => {
+      const instance = await TestFixture.deployed();
+
+      const strict = await instance.decodeAny.call("type.googleapis.com/Unknown", "0x", true);
+      assert.equal(strict[0], false);
+
+      const lenient = await instance.decodeAny.call("type.googleapis.com/Unknown", "0x", false);
+      assert.equal(lenient[0], true);
+      assert.equal(lenient[1], 0);
+    });
+
+    it("type URL without slash", async () => {
+      const instance = await TestFixture.deployed();
+
+      const result = await instance.decodeAny.call("OtherMessage", "0x0807", false);
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("recursive messages", async () => {
+    it("decodes nested nodes", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(RecursiveProtoFile);
+
+      const Node = root.lookupType("Node");
+      const message = Node.create({ value: 1, children: [{ value: 2, children: [{ value: 3 }, { value: 4 }] }] });
+      const encoded = Node.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeNode.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 2);
+      assert.equal(result[2], 2);
+      await instance.decodeNode("0x" + encoded);
+    });
+
+    it("nested children followed by a sibling", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(RecursiveProtoFile);
+
+      // The children of the first child end where its sibling starts, with the
+      // same field number, so must only be counted up to the end of the child
+      const Node = root.lookupType("Node");
+      const message = Node.create({ value: 1, children: [{ value: 2, children: [{ value: 3 }] }, { value: 4 }] });
+      const encoded = Node.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeNode.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 2);
+      assert.equal(result[2], 1);
+    });
+  });
+
+  describe("limits", async () => {
+    // Limits of the Makefile: max_repeated=3, max_repeated.LimitsInner=2,
+    // max_length=4, max_depth=2, max_depth.LimitsLeaf=1, max_size=32
+    const valid = {
+      values: ["1", "2", "3"],
+      name: "abcd",
+      inner: { value: 1 },
+      leaf: { value: 2 },
+    };
+
+    const encode = async (messageObj) => {
+      const root = await protobuf.load(LimitsProtoFile);
+      const LimitsMessage = root.lookupType("LimitsMessage");
+
+      return "0x" + LimitsMessage.encode(LimitsMessage.create(messageObj)).finish().toString("hex");
+    };
+
+    it("valid input", async () => {
+      const instance = await TestFixture.deployed();
+
+      const encoded = await encode(valid);
+
+      const result = await instance.decodeLimits.call(encoded);
+      assert.equal(result[0], true);
+      assert.deepStrictEqual(result[1].values, ["1", "2", "3"]);
+      assert.equal(result[1].name, "abcd");
+      assert.equal(result[1].inner.value, 1);
+      assert.equal(result[1].leaf.value, 2);
+      await instance.decodeLimits(encoded);
+    });
+
+    const inputs = {
+      max_size: [
+        { ...valid, values: ["18446744073709551615", "18446744073709551615", "18446744073709551615"] },
+        "LimitsMessage: max_size exceeded",
+      ],
+      max_repeated: [{ ...valid, values: ["1", "2", "3", "4"] }, "LimitsMessage.values: max_repeated exceeded"],
+      "max_repeated of a message": [
+        { ...valid, inner: { leaves: [{ value: 1 }, { value: 2 }, { value: 3 }] } },
+        "LimitsInner.leaves: max_repeated exceeded",
+      ],
+      max_length: [{ ...valid, name: "abcde" }, "LimitsMessage.name: max_length exceeded"],
+      // A leaf is at depth 1 in the message, and at depth 2 in inner
+      "max_depth of a message": [{ ...valid, inner: { leaves: [{ value: 1 }] } }, "LimitsLeaf: max_depth exceeded"],
+    };
+
+    for (const [name, [messageObj, reason]] of Object.entries(inputs)) {
+      it(name, async () => {
+        const instance = await TestFixture.deployed();
+
+        const encoded = await encode(messageObj);
+
+        await truffleAssert.reverts(instance.decodeLimits.call(encoded), reason);
+      });
+    }
+  });
+
+  describe("empty messages", async () => {
+    it("only zero-length input", async () => {
+      const instance = await TestFixture.deployed();
+
+      assert.equal(await instance.decodeEmptyMessage.call("0x"), true);
+      assert.equal(await instance.decodeEmptyMessage.call("0x0801"), false);
+    });
+
+    it("embedded", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(EmptyMessageProtoFile);
+
+      const EmptyContainer = root.lookupType("EmptyContainer");
+      const message = EmptyContainer.create({ empties: [{}, {}], field: 1 });
+      const encoded = EmptyContainer.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeEmpty.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1].empties.length, 2);
+      assert.equal(result[1].field, 1);
+      await instance.decodeEmpty("0x" + encoded);
+    });
+
+    it("present with the default value", async () => {
+      const instance = await TestFixture.deployed();
+
+      // Field 1 with an empty message must be omitted
+      const result = await instance.decodeEmpty.call("0x0a00");
+      assert.equal(result[0], false);
+    });
+  });
+
+  describe("well-known types", async () => {
+    it("timestamp, duration and wrappers", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(WellKnownProtoFile);
+
+      const Schedule = root.lookupType("Schedule");
+      const scheduleObj = {
+        start: { seconds: 1600000000, nanos: 5 },
+        period: { seconds: -60, nanos: -1 },
+        limit: { value: 0 },
+        label: { value: "daily" },
+      };
+
+      const message = Schedule.create(scheduleObj);
+      const encoded = Schedule.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeSchedule.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1].start.secs, 1600000000);
+      assert.equal(result[1].start.nanos, 5);
+      assert.equal(result[1].period.secs, -60);
+      assert.equal(result[1].period.nanos, -1);
+      // Present with the default value
+      assert.equal(result[1].limit.present, true);
+      assert.equal(result[1].limit.value, 0);
+      assert.equal(result[1].label.present, true);
+      assert.equal(result[1].label.value, "daily");
+      // Absent
+      assert.equal(result[1].enabled.present, false);
+      await instance.decodeSchedule("0x" + encoded);
+    });
+
+    it("nanos out of range", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(WellKnownProtoFile);
+
+      const Schedule = root.lookupType("Schedule");
+      const message = Schedule.create({ start: { seconds: 1, nanos: 1000000000 } });
+      const encoded = Schedule.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeSchedule.call("0x" + encoded);
+      assert.equal(result[0], false);
+    });
+
+    it("duration signs differ", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(WellKnownProtoFile);
+
+      const Schedule = root.lookupType("Schedule");
+      const message = Schedule.create({ period: { seconds: 1, nanos: -1 } });
+      const encoded = Schedule.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeSchedule.call("0x" + encoded);
+      assert.equal(result[0], false);
+    });
+
+    it("timestamp out of range", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(WellKnownProtoFile);
+
+      const Schedule = root.lookupType("Schedule");
+      const message = Schedule.create({ start: { seconds: 253402300800 } });
+      const encoded = Schedule.encode(message).finish().toString("hex");
+
+      const result = await instance.decodeSchedule.call("0x" + encoded);
+      assert.equal(result[0], false);
+    });
+
+    it("unix seconds", async () => {
+      const instance = await TestFixture.deployed();
+
+      const result = await instance.timestamp.call(1600000000);
+      assert.equal(result[0], true);
+      assert.equal(result[1], 1600000000);
+
+      await truffleAssert.reverts(instance.timestamp.call(253402300800), "Timestamp: out of range");
+    });
+  });
+
+  describe("storage", async () => {
+    it("store and load", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        optionalMessage: { otherField: 3 },
+        repeatedSint64: ["-690", "-689"],
+        repeatedEnum: ["1", "2"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }, { otherField: 3 }],
+      };
+
+      const message = Message.create(messageObj);
+      const encoded = Message.encode(message).finish().toString("hex");
+
+      const expected = await instance.decode.call("0x" + encoded);
+      await instance.decodeAndStore("0x" + encoded);
+      const result = await instance.load.call();
+      assert.deepStrictEqual(result, expected[1]);
+    });
+
+    it("clears stale elements", async () => {
+      const instance = await TestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        repeatedMessage: [{ otherField: 4 }],
+      };
+
+      const message = Message.create(messageObj);
+      const encoded = Message.encode(message).finish().toString("hex");
+
+      const expected = await instance.decode.call("0x" + encoded);
+      await instance.decodeAndStore("0x" + encoded);
+      const result = await instance.load.call();
+      assert.equal(result.repeated_message.length, 1);
+      assert.deepStrictEqual(result, expected[1]);
+    });
+  });
 });
@@ -0,0 +1,2 @@
+// Codecs generated by the table backend
+module.exports = require("./truffle-variant")("table");
@@ -0,0 +1,14 @@
+const path = require("path");
+
+const config = require("./truffle-config");
+
+// Configuration of a truffle project in a subdirectory, for codecs generated
+// with other parameters. Codecs define the same messages whatever the
+// parameters, so each set of codecs is compiled and tested separately.
+module.exports = (name) => ({
+  ...config,
+  contracts_directory: path.join(__dirname, name, "contracts"),
+  contracts_build_directory: path.join(__dirname, "build", name),
+  migrations_directory: path.join(__dirname, name, "migrations"),
+  test_directory: path.join(__dirname, name, "test"),
+});
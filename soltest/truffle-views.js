@@ -0,0 +1,2 @@
+// Codecs with string and bytes fields decoded as views
+module.exports = require("./truffle-variant")("views");
@@ -0,0 +1,2 @@
+// Codecs generated by the yul backend with the packed layout
+module.exports = require("./truffle-variant")("yul_packed");
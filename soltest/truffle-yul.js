@@ -0,0 +1,2 @@
+// Codecs generated by the yul backend
+module.exports = require("./truffle-variant")("yul");
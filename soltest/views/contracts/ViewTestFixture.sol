@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+pragma solidity >=0.6.0 <8.0.0;
+pragma experimental ABIEncoderV2;
+
+import "./all_features.proto.sol";
+
+// Same messages as TestFixture, with string and bytes fields decoded as views
+contract ViewTestFixture {
+    // Functions are not pure so that we can measure gas
+
+    function decode(bytes memory buf) public returns (bool, Message memory) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function optionalString(bytes memory buf) public returns (bool, string memory, bytes32) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, ProtobufSupportLib.to_string(instance.optional_string), ProtobufSupportLib.hash(instance.optional_string));
+    }
+
+    function optionalBytes(bytes memory buf) public returns (bool, bytes memory, bytes32) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, ProtobufSupportLib.to_bytes(instance.optional_bytes), ProtobufSupportLib.hash(instance.optional_bytes));
+    }
+
+    function optionalBytesEquals(bytes memory buf, bytes memory data) public returns (bool, bool) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, ProtobufSupportLib.equals(instance.optional_bytes, data));
+    }
+
+    function reencode(bytes memory buf) public returns (bool, bytes memory) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+        if (!success) {
+            return (false, "");
+        }
+
+        return (true, MessageCodec.encode(instance));
+    }
+}
@@ -0,0 +1,5 @@
+const ViewTestFixture = artifacts.require("ViewTestFixture");
+
+module.exports = function (deployer) {
+  deployer.deploy(ViewTestFixture);
+};
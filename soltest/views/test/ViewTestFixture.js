@@ -0,0 +1,125 @@
+const protobuf = require("protobufjs");
+
+const ViewTestFixture = artifacts.require("ViewTestFixture");
+
+const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+
+contract("ViewTestFixture", async (accounts) => {
+  describe("constructor", async () => {
+    it("should deploy", async () => {
+      await ViewTestFixture.deployed();
+    });
+  });
+
+  //////////////////////////////////////
+  // NOTICE
+  // Tests call functions twice, once to run and another to measure gas.
+  //////////////////////////////////////
+
+  describe("views", async () => {
+    const messageObj = {
+      optionalInt32: -42,
+      optionalString: "foorbar",
+      optionalBytes: Buffer.from("deadbeef", "hex"),
+      optionalMessage: { otherField: 3 },
+      repeatedSint32: ["-69", "-68"],
+      repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+    };
+
+    const encode = async (obj) => {
+      const root = await protobuf.load(AllFeaturesProtoFile);
+      const Message = root.lookupType("Message");
+      return "0x" + Message.encode(Message.create(obj)).finish().toString("hex");
+    };
+
+    it("range of the decoded buffer", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode(messageObj);
+
+      // optional_string is the first length-delimited field, after the key
+      // and value of optional_int32 and its own key and length
+      const result = await instance.decode.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1].optional_int32, -42);
+      assert.equal(result[1].optional_string.buf, encoded);
+      assert.equal(result[1].optional_string.offset, 13);
+      assert.equal(result[1].optional_string.length, 7);
+
+      await instance.decode(encoded);
+    });
+
+    it("hash and to_string", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode(messageObj);
+
+      const result = await instance.optionalString.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], "foorbar");
+      assert.equal(result[2], web3.utils.keccak256(web3.utils.utf8ToHex("foorbar")));
+
+      await instance.optionalString(encoded);
+    });
+
+    it("hash and to_bytes", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode(messageObj);
+
+      const result = await instance.optionalBytes.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], "0xdeadbeef");
+      assert.equal(result[2], web3.utils.keccak256("0xdeadbeef"));
+
+      await instance.optionalBytes(encoded);
+    });
+
+    it("absent field", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode({ optionalInt32: -42 });
+
+      // The view of a default field is empty
+      const result = await instance.optionalBytes.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[2], web3.utils.keccak256("0x"));
+
+      const equals = await instance.optionalBytesEquals.call(encoded, "0x");
+      assert.equal(equals[1], true);
+    });
+
+    it("equals", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode(messageObj);
+
+      let result = await instance.optionalBytesEquals.call(encoded, "0xdeadbeef");
+      assert.equal(result[0], true);
+      assert.equal(result[1], true);
+
+      result = await instance.optionalBytesEquals.call(encoded, "0xdeadbeee");
+      assert.equal(result[1], false);
+
+      result = await instance.optionalBytesEquals.call(encoded, "0xdeadbe");
+      assert.equal(result[1], false);
+
+      result = await instance.optionalBytesEquals.call(encoded, "0xdeadbeef00");
+      assert.equal(result[1], false);
+
+      await instance.optionalBytesEquals(encoded, "0xdeadbeef");
+    });
+
+    it("encode", async () => {
+      const instance = await ViewTestFixture.deployed();
+
+      const encoded = await encode(messageObj);
+
+      const result = await instance.reencode.call(encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1], encoded);
+
+      await instance.reencode(encoded);
+    });
+  });
+});
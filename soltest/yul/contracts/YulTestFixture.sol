@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+pragma solidity >=0.6.0 <8.0.0;
+pragma experimental ABIEncoderV2;
+
+import "./all_features.proto.sol";
+import "./wide_message.proto.sol";
+
+// Same messages as TestFixture, with codecs generated by the yul backend
+contract YulTestFixture {
+    // Functions are not pure so that we can measure gas
+
+    function decode(bytes memory buf) public returns (bool, Message memory) {
+        (bool success, uint64 pos, Message memory instance) = MessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function decodeWide(bytes memory buf) public returns (bool, WideMessage memory) {
+        (bool success, uint64 pos, WideMessage memory instance) = WideMessageCodec.decode(0, buf, uint64(buf.length));
+
+        return (success, instance);
+    }
+
+    function encode(Message memory instance) public returns (bytes memory) {
+        return MessageCodec.encode(instance);
+    }
+
+    function hash(Message memory instance) public returns (bytes32, bytes32) {
+        return (MessageCodec.hash(instance), MessageCodec.hash_sha256(instance));
+    }
+
+    function merkleRoot(Message memory instance) public returns (bytes32) {
+        return MessageCodec.merkle_root(instance);
+    }
+
+    function verifyFieldProof(
+        bytes32 root,
+        uint64 field_number,
+        bytes memory leaf,
+        bytes32[] memory aunts
+    ) public returns (bool) {
+        return MessageCodec.verify_field_proof(root, field_number, leaf, aunts);
+    }
+}
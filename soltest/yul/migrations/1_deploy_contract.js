@@ -0,0 +1,5 @@
+const YulTestFixture = artifacts.require("YulTestFixture");
+
+module.exports = function (deployer) {
+  deployer.deploy(YulTestFixture);
+};
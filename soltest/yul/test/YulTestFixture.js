@@ -0,0 +1,78 @@
+const crypto = require("crypto");
+const protobuf = require("protobufjs");
+
+const { leafHash, merkleRoot, merkleAunts } = require("../../helpers");
+const testCodec = require("../../codec-tests");
+
+const YulTestFixture = artifacts.require("YulTestFixture");
+
+const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+
+contract("YulTestFixture", async (accounts) => {
+  testCodec(YulTestFixture);
+
+  describe("hash", async () => {
+    it("in place", async () => {
+      const instance = await YulTestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const messageObj = {
+        optionalInt32: -42,
+        optionalString: "foorbar",
+        optionalMessage: { otherField: 3 },
+        repeatedSint32: ["-69", "-68"],
+        repeatedMessage: [{ otherField: 1 }, { otherField: 2 }],
+      };
+
+      const encoded = Message.encode(Message.create(messageObj)).finish();
+
+      const decoded = await instance.decode.call("0x" + encoded.toString("hex"));
+      assert.equal(decoded[0], true);
+
+      const result = await instance.hash.call(decoded[1]);
+      assert.equal(result[0], web3.utils.keccak256("0x" + encoded.toString("hex")));
+      assert.equal(result[1], "0x" + crypto.createHash("sha256").update(encoded).digest("hex"));
+
+      await instance.hash(decoded[1]);
+    });
+  });
+
+  describe("merkle", async () => {
+    it("root and field proof", async () => {
+      const instance = await YulTestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const OtherMessage = root.lookupType("OtherMessage");
+
+      // optional_int32 is field 1, so its leaf is the encoding of a Message
+      // with only that field, and the leaf of optional_message (field 15) is
+      // the encoding of the embedded message. Default fields have empty leaves.
+      const leaves = new Array(28).fill(Buffer.alloc(0));
+      leaves[0] = Buffer.from(Message.encode(Message.create({ optionalInt32: -42 })).finish());
+      leaves[14] = Buffer.from(OtherMessage.encode(OtherMessage.create({ otherField: 3 })).finish());
+      const hashes = leaves.map(leafHash);
+      const expected = "0x" + merkleRoot(hashes).toString("hex");
+
+      const message = Message.create({ optionalInt32: -42, optionalMessage: { otherField: 3 } });
+      const encoded = Message.encode(message).finish().toString("hex");
+
+      const decoded = await instance.decode.call("0x" + encoded);
+      assert.equal(decoded[0], true);
+
+      const result = await instance.merkleRoot.call(decoded[1]);
+      assert.equal(result, expected);
+
+      const aunts = merkleAunts(hashes, 14).map((h) => "0x" + h.toString("hex"));
+      const leaf = "0x" + leaves[14].toString("hex");
+      assert.equal(await instance.verifyFieldProof.call(expected, 15, leaf, aunts), true);
+      assert.equal(await instance.verifyFieldProof.call(expected, 14, leaf, aunts), false);
+      assert.equal(await instance.verifyFieldProof.call(expected, 15, "0x", aunts), false);
+
+      await instance.merkleRoot(decoded[1]);
+    });
+  });
+});
@@ -0,0 +1 @@
+../../../test/pass/all_features/yul_packed/ProtobufSupportLib.sol
\ No newline at end of file
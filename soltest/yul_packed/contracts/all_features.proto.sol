@@ -0,0 +1 @@
+../../../test/pass/all_features/yul_packed/all_features.proto.sol
\ No newline at end of file
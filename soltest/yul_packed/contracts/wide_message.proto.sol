@@ -0,0 +1 @@
+../../../test/pass/wide_message/yul_packed/wide_message.proto.sol
\ No newline at end of file
@@ -0,0 +1,5 @@
+const YulPackedTestFixture = artifacts.require("YulPackedTestFixture");
+
+module.exports = function (deployer) {
+  deployer.deploy(YulPackedTestFixture);
+};
@@ -0,0 +1,30 @@
+const protobuf = require("protobufjs");
+
+const testCodec = require("../../codec-tests");
+
+const YulPackedTestFixture = artifacts.require("YulPackedTestFixture");
+
+const AllFeaturesProtoFile = "../test/pass/all_features/all_features.proto";
+
+contract("YulPackedTestFixture", async (accounts) => {
+  testCodec(YulPackedTestFixture);
+
+  describe("layout", async () => {
+    it("members in layout order", async () => {
+      const instance = await YulPackedTestFixture.deployed();
+
+      const root = await protobuf.load(AllFeaturesProtoFile);
+
+      const Message = root.lookupType("Message");
+      const message = Message.create({ optionalInt32: -42, optionalString: "foorbar" });
+      const encoded = Message.encode(message).finish().toString("hex");
+
+      // Dynamic members come first, so optional_string is the first member and
+      // optional_int32 follows the 64-bit members
+      const result = await instance.decode.call("0x" + encoded);
+      assert.equal(result[0], true);
+      assert.equal(result[1][0], "foorbar");
+      assert.equal(result[1][21], "-42");
+    });
+  });
+});